@@ -0,0 +1,186 @@
+package offheap
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// ArenaItemsNum is how many items a P carves at once from the current mmap
+// region of a pool
+const ArenaItemsNum = 64
+
+// arenaCarveFunc carves at most itemsNum items from the current mmap region
+// of a pool, and returns the address range [start, end) of the items carved
+type arenaCarveFunc func(itemsNum int) (start uintptr, end uintptr, err error)
+
+type arenaInternal struct {
+	sync.Mutex // Protects the refill of the arena.
+	start      uintptr
+	end        uintptr
+}
+
+type arena struct {
+	arenaInternal
+
+	// Prevents false sharing on widespread platforms with
+	// 128 mod (cache line size) = 0 .
+	pad [128 - unsafe.Sizeof(arenaInternal{})%128]byte
+}
+
+// arenas are the per-P bump allocators of a pool, so that Ps do not contend
+// on the current mmap region of the pool
+type arenas struct {
+	itemSize  uintptr
+	itemsNum  int
+	carveFunc arenaCarveFunc
+	arenas    []arena
+
+	// drainedItems are the items drained and given back by putDrained, they
+	// are carved again before carveFunc is invoked
+	drainedMutex sync.Mutex
+	drainedItems []uintptr
+}
+
+func (p *arenas) init(itemSize uintptr, perMmapBytesSize int, carveFunc arenaCarveFunc) {
+	p.itemSize = itemSize
+	p.carveFunc = carveFunc
+	p.itemsNum = ArenaItemsNum
+	if p.itemsNum > perMmapBytesSize/int(itemSize) {
+		p.itemsNum = perMmapBytesSize / int(itemSize)
+	}
+	p.arenas = make([]arena, runtime.GOMAXPROCS(0))
+}
+
+func (p *arenas) isEnabled() bool {
+	return len(p.arenas) > 0
+}
+
+// alloc returns an item from the arena of the current P, which is refilled
+// by carveFunc if empty
+func (p *arenas) alloc() (uintptr, error) {
+	var (
+		pid   int
+		arena *arena
+		ret   uintptr
+		err   error
+	)
+
+	pid = runtime.UnsafeProcPin()
+	runtime.UnsafeProcUnpin()
+	arena = &p.arenas[pid%len(p.arenas)]
+
+	// the goroutine may move to another P after unpin, so the arena is
+	// still taken by CAS, which is barely contended
+	ret = arena.take(p.itemSize)
+	if ret != 0 {
+		return ret, nil
+	}
+
+	arena.Lock()
+	ret = arena.take(p.itemSize)
+	if ret == 0 {
+		ret = p.takeDrained()
+	}
+	if ret == 0 {
+		var start, end uintptr
+		start, end, err = p.carveFunc(p.itemsNum)
+		if err == nil {
+			// end is cleared first so that take does not see the new start
+			// with the old end
+			atomic.StoreUintptr(&arena.end, 0)
+			atomic.StoreUintptr(&arena.start, start+p.itemSize)
+			atomic.StoreUintptr(&arena.end, end)
+			ret = start
+		}
+	}
+	arena.Unlock()
+
+	return ret, err
+}
+
+// take returns the first item of the arena, or 0 if the arena is empty
+func (p *arena) take(itemSize uintptr) uintptr {
+	for {
+		start := atomic.LoadUintptr(&p.start)
+		end := atomic.LoadUintptr(&p.end)
+		if start+itemSize > end {
+			return 0
+		}
+		if atomic.CompareAndSwapUintptr(&p.start, start, start+itemSize) {
+			return start
+		}
+	}
+}
+
+// takeDrained returns an item given back by putDrained, or 0
+func (p *arenas) takeDrained() uintptr {
+	var ret uintptr
+
+	p.drainedMutex.Lock()
+	if last := len(p.drainedItems) - 1; last >= 0 {
+		ret = p.drainedItems[last]
+		p.drainedItems = p.drainedItems[:last]
+	}
+	p.drainedMutex.Unlock()
+
+	return ret
+}
+
+// putDrained gives back items returned by drain, they are never alloced yet
+func (p *arenas) putDrained(items []uintptr) {
+	p.drainedMutex.Lock()
+	p.drainedItems = append(p.drainedItems, items...)
+	p.drainedMutex.Unlock()
+}
+
+// drain empties every arena and returns the items left in them, and the
+// ones given back by putDrained
+func (p *arenas) drain() []uintptr {
+	var ret []uintptr
+
+	p.drainedMutex.Lock()
+	ret = p.drainedItems
+	p.drainedItems = nil
+	p.drainedMutex.Unlock()
+
+	for i := range p.arenas {
+		arena := &p.arenas[i]
+		arena.Lock()
+		for {
+			start := atomic.LoadUintptr(&arena.start)
+			end := atomic.LoadUintptr(&arena.end)
+			if start >= end {
+				break
+			}
+			if atomic.CompareAndSwapUintptr(&arena.start, start, end) {
+				for uItem := start; uItem < end; uItem += p.itemSize {
+					ret = append(ret, uItem)
+				}
+				break
+			}
+		}
+		arena.Unlock()
+	}
+	return ret
+}
+
+// carveMmapBytes carves at most itemsNum items from currentMmapBytes, it
+// returns 0, 0 if currentMmapBytes has no room left for one item
+func carveMmapBytes(currentMmapBytes *mmapbytes, itemSize uintptr, itemsNum int) (uintptr, uintptr) {
+	var start, end uintptr
+	for {
+		start = atomic.LoadUintptr(&currentMmapBytes.addrStart)
+		if start+itemSize > currentMmapBytes.addrEnd {
+			return 0, 0
+		}
+		end = start + itemSize*uintptr(itemsNum)
+		if end > currentMmapBytes.addrEnd {
+			end = start + (currentMmapBytes.addrEnd-start)/itemSize*itemSize
+		}
+		if atomic.CompareAndSwapUintptr(&currentMmapBytes.addrStart, start, end) {
+			return start, end
+		}
+	}
+}
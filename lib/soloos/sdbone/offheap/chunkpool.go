@@ -1,9 +1,12 @@
 package offheap
 
 import (
+	"context"
 	"math"
+	"os"
 	"sync"
 	"sync/atomic"
+	"syscall"
 )
 
 type ChunkPoolInvokePrepareNewChunk func(uChunk uintptr)
@@ -13,7 +16,9 @@ type ChunkPoolInvokeReleaseChunk func()
 // user -> AllocChunk -> mallocChunk -> user
 // user -> AllocChunk -> ChunkPoolAssistant.ChunkPoolInvokeReleaseChunk -> ReleaseChunk -> user
 type ChunkPool struct {
-	ID int64
+	ID            int64
+	offheapDriver *OffheapDriver
+	options       PoolOptions
 
 	chunkSize   uintptr
 	chunksLimit int32
@@ -26,45 +31,95 @@ type ChunkPool struct {
 	currentMmapBytes    *mmapbytes
 	mmapBytesList       []*mmapbytes
 
-	maxChunkID      int64
-	chunksMutex     sync.Mutex
-	activeChunksNum int32
-	pool            NoGCUintptrPool
-	chunks          map[uintptr]uintptr
+	maxChunkID            int64
+	chunksMutex           sync.Mutex
+	activeChunksNum       int32
+	releasedChunksNum     int64
+	releaseChunkInvokeNum int64
+	pool                  NoGCUintptrPool
+	idleChunks            idleChunks
+	arenas                arenas
+	waiters               chunkWaiters
+	debugger              *poolDebugger
+	chunks                map[uintptr]uintptr
+
+	file                *os.File
+	fileHeaderMmapBytes mmapbytes
+	fileHeader          *chunkPoolFileHeader
+	recoveredChunks     []ChunkUintptr
+	// freeFileChunks are the free chunks of a ChunkPool inited by InitWithFile,
+	// guarded by chunksMutex. They are not put in pool, which may drop chunks
+	// and would let Sync save them as alive.
+	freeFileChunks []uintptr
 }
 
 func (p *ChunkPool) Init(id int64, chunkSize int, chunksLimit int32,
 	prepareNewChunkFunc ChunkPoolInvokePrepareNewChunk,
-	releaseChunkFunc ChunkPoolInvokeReleaseChunk) error {
+	releaseChunkFunc ChunkPoolInvokeReleaseChunk,
+	options ...PoolOptions) error {
 	var (
 		err error
 	)
 
+	p.options = getPoolOptions(options)
+	p.prepare(id, chunkSize, chunksLimit, prepareNewChunkFunc, releaseChunkFunc)
+
+	err = p.growMmapBytesList()
+	if err != nil {
+		return err
+	}
+
+	p.activeChunksNum = 0
+
+	if p.options.DisableArenas == false {
+		p.arenas.init(p.chunkWithStructSize, p.perMmapBytesSize, p.carveChunks)
+	}
+
+	if p.options.Debug {
+		p.debugger = newPoolDebugger(p.ID, ChunkStructSize, p.chunkSize, p.options)
+	}
+
+	if p.options.FreeChunkIdleDuration > 0 {
+		p.idleChunks.start(p.options.FreeChunkIdleDuration, p.AdviseIdleChunks)
+	}
+
+	return nil
+}
+
+func (p *ChunkPool) prepare(id int64, chunkSize int, chunksLimit int32,
+	prepareNewChunkFunc ChunkPoolInvokePrepareNewChunk,
+	releaseChunkFunc ChunkPoolInvokeReleaseChunk) {
 	p.ID = id
 	p.chunkSize = uintptr(chunkSize)
 	p.chunkWithStructSize = ChunkStructSize + p.chunkSize
+	if p.options.Debug {
+		p.chunkWithStructSize = debugChunkSize(p.chunkWithStructSize)
+	}
 	p.chunksLimit = chunksLimit
 	if p.chunksLimit == -1 {
 		p.perMmapBytesSize = int(1024 * int(p.chunkWithStructSize))
 	} else {
 		p.perMmapBytesSize = int(math.Ceil(float64(p.chunksLimit)/float64(16))) * int(p.chunkWithStructSize)
 	}
+	if p.options.HugePage != MmapHugePageNone && p.options.Debug == false {
+		p.perMmapBytesSize = (p.perMmapBytesSize + HugePageSize - 1) / HugePageSize * HugePageSize
+	}
 	p.prepareNewChunkFunc = prepareNewChunkFunc
 	p.releaseChunkFunc = releaseChunkFunc
-
-	err = p.growMmapBytesList()
-	if err != nil {
-		return err
-	}
-
-	p.activeChunksNum = 0
-	p.pool.New = p.mallocChunk
-
-	return nil
 }
 
 func (p *ChunkPool) growMmapBytesList() error {
-	mmapBytes, err := AllocMmapBytes(int(p.perMmapBytesSize))
+	var (
+		mmapBytes mmapbytes
+		err       error
+	)
+	if p.file != nil {
+		mmapBytes, err = p.allocMmapBytesWithFile(len(p.mmapBytesList))
+	} else if p.options.Debug {
+		mmapBytes, err = AllocMmapBytesWithGuardPage(p.perMmapBytesSize)
+	} else {
+		mmapBytes, err = AllocMmapBytesWithHugePage(int(p.perMmapBytesSize), p.options.HugePage)
+	}
 	if err != nil {
 		return err
 	}
@@ -74,79 +129,336 @@ func (p *ChunkPool) growMmapBytesList() error {
 	return nil
 }
 
-func (p *ChunkPool) mallocChunk() uintptr {
+// carveChunks carves at most chunksNum chunks from the current mmap region,
+// and grows the mmap regions if it is full
+func (p *ChunkPool) carveChunks(chunksNum int) (uintptr, uintptr, error) {
 	var (
-		uChunk           ChunkUintptr
 		currentMmapBytes *mmapbytes
-		end              uintptr
+		start, end       uintptr
 		err              error
 	)
 
-	// step1 grow mem if need
-	if err == nil {
-		currentMmapBytes = p.currentMmapBytes
-		end = atomic.AddUintptr(&currentMmapBytes.addrStart, p.chunkWithStructSize)
-		if end > currentMmapBytes.addrEnd {
-			p.chunksMutex.Lock()
-			currentMmapBytes = p.currentMmapBytes
-			end = atomic.AddUintptr(&currentMmapBytes.addrStart, p.chunkWithStructSize)
-			if end < currentMmapBytes.addrEnd {
-				p.chunksMutex.Unlock()
-				goto STEP1_DONE
-			}
-
+	currentMmapBytes = p.currentMmapBytes
+	start, end = carveMmapBytes(currentMmapBytes, p.chunkWithStructSize, chunksNum)
+	for start == 0 {
+		p.chunksMutex.Lock()
+		if currentMmapBytes == p.currentMmapBytes {
 			err = p.growMmapBytesList()
 			if err != nil {
 				p.chunksMutex.Unlock()
-				goto STEP1_DONE
+				return 0, 0, err
 			}
-
-			currentMmapBytes = p.currentMmapBytes
-			end = currentMmapBytes.addrStart + p.chunkWithStructSize
-			currentMmapBytes.addrStart = end
-			p.chunksMutex.Unlock()
 		}
+		currentMmapBytes = p.currentMmapBytes
+		p.chunksMutex.Unlock()
+		start, end = carveMmapBytes(currentMmapBytes, p.chunkWithStructSize, chunksNum)
+	}
+
+	return start, end, nil
+}
+
+func (p *ChunkPool) mallocChunk() (uintptr, error) {
+	var (
+		start uintptr
+		err   error
+	)
+
+	// step1 carve mem, from the arena of the current P if enabled
+	if p.arenas.isEnabled() {
+		start, err = p.arenas.alloc()
+	} else {
+		start, _, err = p.carveChunks(1)
+	}
+	if err != nil {
+		return 0, err
 	}
-STEP1_DONE:
 
 	// step2 alloc mem for chunk
-	if err == nil {
-		// get chunk address
-		uChunk = (ChunkUintptr)(end - p.chunkWithStructSize)
+	p.prepareNewChunk(start)
+	return start, nil
+}
+
+func (p *ChunkPool) prepareNewChunk(uChunk uintptr) {
+	// save chunk in offheap.data
+	ChunkUintptr(uChunk).Ptr().ID = atomic.AddInt64(&p.maxChunkID, 1)
+	ChunkUintptr(uChunk).Ptr().Data = uChunk + ChunkStructSize
 
-		// save chunk in offheap.data
-		uChunk.Ptr().ID = atomic.AddInt64(&p.maxChunkID, 1)
-		uChunk.Ptr().Data = uintptr(uChunk) + ChunkStructSize
+	if p.prepareNewChunkFunc != nil {
+		p.prepareNewChunkFunc(uChunk)
+	}
+}
+
+func (p *ChunkPool) allocChunk() (ChunkUintptr, error) {
+	var uChunk uintptr
+	if p.file != nil {
+		uChunk = p.popFileFreeChunk()
+	} else {
+		uChunk = p.pool.Get()
+	}
+	if uChunk == 0 && p.options.FreeChunkIdleDuration > 0 {
+		uChunk = p.idleChunks.pop()
+		if uChunk != 0 && p.prepareNewChunkFunc != nil {
+			p.prepareNewChunkFunc(uChunk)
+		}
+	}
+	if uChunk != 0 {
+		if p.debugger != nil {
+			p.debugger.alloc(uChunk)
+		}
+		return ChunkUintptr(uChunk), nil
 	}
 
+	uChunk, err := p.mallocChunk()
+	if err == nil && p.debugger != nil {
+		p.debugger.alloc(uChunk)
+	}
+	return ChunkUintptr(uChunk), err
+}
+
+func (p *ChunkPool) reserveChunk() bool {
+	for {
+		activeChunksNum := atomic.LoadInt32(&p.activeChunksNum)
+		if activeChunksNum >= p.chunksLimit {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&p.activeChunksNum, activeChunksNum, activeChunksNum+1) {
+			return true
+		}
+	}
+}
+
+// unreserveChunk gives back a chunk reserved by reserveChunk
+func (p *ChunkPool) unreserveChunk() {
+	atomic.AddInt32(&p.activeChunksNum, -1)
+	atomic.AddInt64(&p.releasedChunksNum, 1)
+	p.waiters.broadcast()
+}
+
+// releasedChunksNumFunc counts the chunks given back, allocators retry to
+// reserve a chunk while it grows
+func (p *ChunkPool) releasedChunksNumFunc() int64 {
+	return atomic.LoadInt64(&p.releasedChunksNum)
+}
+
+func (p *ChunkPool) invokeReleaseChunk() {
+	atomic.AddInt64(&p.releaseChunkInvokeNum, 1)
+	p.releaseChunkFunc()
+}
+
+// AllocChunkCtx allocs a chunk, waiting for a chunk to be released while
+// chunksLimit is reached and releaseChunkFunc does not release any.
+// It returns ErrAllocChunkOurOfLimit if the deadline of ctx is exceeded,
+// ctx.Err() if ctx is canceled, and ErrMmap if the ChunkPool can not grow.
+func (p *ChunkPool) AllocChunkCtx(ctx context.Context) (ChunkUintptr, error) {
+	var (
+		uChunk           ChunkUintptr
+		releaseChunkFunc ChunkPoolInvokeReleaseChunk
+		err              error
+	)
+
+	if p.chunksLimit == -1 {
+		return p.allocUnlimitedChunk()
+	}
+
+	if p.releaseChunkFunc != nil {
+		releaseChunkFunc = p.invokeReleaseChunk
+	}
+	err = p.waiters.reserve(ctx, p.releasedChunksNumFunc, p.reserveChunk, releaseChunkFunc)
 	if err != nil {
-		panic("malloc chunk error")
+		return 0, err
 	}
 
-	if p.prepareNewChunkFunc != nil {
-		p.prepareNewChunkFunc(uintptr(uChunk))
+	uChunk, err = p.allocChunk()
+	if err != nil {
+		p.unreserveChunk()
+		return 0, err
 	}
-	return uintptr(uChunk)
+
+	return uChunk, nil
+}
+
+func (p *ChunkPool) allocUnlimitedChunk() (ChunkUintptr, error) {
+	uChunk, err := p.allocChunk()
+	if err != nil {
+		return 0, err
+	}
+	atomic.AddInt32(&p.activeChunksNum, 1)
+	return uChunk, nil
 }
 
+// AllocChunk is AllocChunkCtx without deadline, it panics if the ChunkPool can not grow
 func (p *ChunkPool) AllocChunk() ChunkUintptr {
+	uChunk, err := p.AllocChunkCtx(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	return uChunk
+}
+
+// TryAllocChunk is AllocChunk returning an error instead of panicking or
+// waiting forever. It returns ErrMmap if the ChunkPool can not grow, and
+// ErrAllocChunkOurOfLimit if chunksLimit is reached and releaseChunkFunc
+// stops releasing chunks.
+func (p *ChunkPool) TryAllocChunk() (ChunkUintptr, error) {
+	var (
+		uChunk            ChunkUintptr
+		releasedChunksNum int64
+		err               error
+	)
+
 	if p.chunksLimit == -1 {
-		return ChunkUintptr(p.pool.Get())
+		return p.allocUnlimitedChunk()
 	}
 
-	// assert p.chunkReleaser != nil
-	if atomic.AddInt32(&p.activeChunksNum, 1) < p.chunksLimit {
-		return ChunkUintptr(p.pool.Get())
+	for p.reserveChunk() == false {
+		if p.releaseChunkFunc == nil {
+			return 0, ErrAllocChunkOurOfLimit
+		}
+		releasedChunksNum = p.releasedChunksNumFunc()
+		p.invokeReleaseChunk()
+		// another allocator may take the released chunk first, so the
+		// reserve is retried as long as chunks are released
+		if p.releasedChunksNumFunc() == releasedChunksNum {
+			return 0, ErrAllocChunkOurOfLimit
+		}
 	}
 
-	for p.activeChunksNum > p.chunksLimit {
-		p.releaseChunkFunc()
+	uChunk, err = p.allocChunk()
+	if err != nil {
+		p.unreserveChunk()
+		return 0, err
 	}
 
-	return ChunkUintptr(p.pool.Get())
+	return uChunk, nil
 }
 
 func (p *ChunkPool) ReleaseChunk(uChunk uintptr) {
-	atomic.AddInt32(&p.activeChunksNum, -1)
-	p.pool.Put(uChunk)
+	if p.debugger != nil {
+		var released bool
+		// the chunk is put in quarantine, and another one may leave it
+		uChunk, released = p.debugger.release(uChunk)
+		if released == false {
+			return
+		}
+	}
+	if p.file != nil {
+		p.putFileFreeChunk(uChunk)
+	} else {
+		p.pool.Put(uChunk)
+	}
+	p.unreserveChunk()
+}
+
+// Shrink unmaps every mmap region whose chunks are all back in the ChunkPool,
+// and returns the number of bytes released.
+// The region chunks are currently carved from is always kept.
+func (p *ChunkPool) Shrink() (int, error) {
+	var (
+		freeChunks      []uintptr
+		freeIdleChunks  []uintptr
+		freeArenaChunks []uintptr
+		releasedSize    int
+		err             error
+	)
+
+	if p.file != nil {
+		// chunks in file must keep their offset
+		return 0, nil
+	}
+
+	// arenas lock chunksMutex when refilled
+	freeArenaChunks = p.arenas.drain()
+
+	p.chunksMutex.Lock()
+	freeChunks = append(freeChunks, freeArenaChunks...)
+	freeChunks = append(freeChunks, p.pool.Drain()...)
+	if p.debugger != nil {
+		freeChunks = append(freeChunks, p.debugger.flush()...)
+	}
+	freeIdleChunks = p.idleChunks.takeAll()
+	freeChunks = append(freeChunks, freeIdleChunks...)
+	p.mmapBytesList, freeChunks, releasedSize, err = shrinkMmapBytesList(p.mmapBytesList,
+		p.currentMmapBytes, p.chunkWithStructSize, freeChunks)
+	freeChunks, freeIdleChunks = splitChunks(freeChunks, freeIdleChunks)
+	// chunks of arenas are not prepared yet, so they go back to arenas
+	freeChunks, freeArenaChunks = splitChunks(freeChunks, freeArenaChunks)
+	for _, uChunk := range freeChunks {
+		p.pool.Put(uChunk)
+	}
+	p.idleChunks.putAll(freeIdleChunks)
+	p.arenas.putDrained(freeArenaChunks)
+	p.chunksMutex.Unlock()
+
+	return releasedSize, err
+}
+
+// Close unmaps every mmap region of the ChunkPool and unregisters it from
+// its OffheapDriver. Chunks allocated from the ChunkPool must not be used after Close.
+// A ChunkPool inited by InitWithFile is synced to its file before Close.
+func (p *ChunkPool) Close() error {
+	var err error
+
+	if p.offheapDriver != nil {
+		p.offheapDriver.DeleteChunkPool(p.ID)
+		p.offheapDriver = nil
+	}
+
+	if p.file != nil {
+		err = p.closeFile()
+	}
+
+	p.idleChunks.stop()
+	p.arenas.drain()
+
+	p.chunksMutex.Lock()
+	p.pool.Reset()
+	for _, mmapBytes := range p.mmapBytesList {
+		if freeErr := FreeMmapBytes(mmapBytes); freeErr != nil && err == nil {
+			err = freeErr
+		}
+	}
+	p.mmapBytesList = nil
+	p.currentMmapBytes = nil
+	atomic.StoreInt32(&p.activeChunksNum, 0)
+	p.recoveredChunks = nil
+	p.freeFileChunks = nil
+	p.chunksMutex.Unlock()
+
+	return err
+}
+
+// AdviseIdleChunks gives back to the kernel the pages of free chunks which
+// stayed free since the last AdviseIdleChunks, and returns the number of bytes
+// advised. It is invoked every PoolOptions.FreeChunkIdleDuration if set.
+func (p *ChunkPool) AdviseIdleChunks() int {
+	if p.file != nil {
+		// MADV_DONTNEED does not free the pages of a file
+		return 0
+	}
+
+	return p.idleChunks.advise(p.pool.DrainIdle(), func(uChunk uintptr) int {
+		return adviseMmapBytes(uChunk+ChunkStructSize, uChunk+p.chunkWithStructSize, syscall.MADV_DONTNEED)
+	})
+}
+
+// Stats returns a snapshot of the statistics of the ChunkPool
+func (p *ChunkPool) Stats() PoolStats {
+	var ret = PoolStats{
+		ID:                    p.ID,
+		ChunkSize:             int(p.chunkSize),
+		ChunksLimit:           p.chunksLimit,
+		ActiveChunksNum:       atomic.LoadInt32(&p.activeChunksNum),
+		FreeChunksNum:         p.pool.Len() + p.idleChunks.len(),
+		ReleaseChunkInvokeNum: atomic.LoadInt64(&p.releaseChunkInvokeNum),
+		WaitersNum:            p.waiters.len(),
+	}
+	ret.BytesInUse = int64(ret.ActiveChunksNum) * int64(p.chunkSize)
+
+	p.chunksMutex.Lock()
+	ret.MmapBytesNum = len(p.mmapBytesList)
+	ret.MmapBytesSize = mmapBytesListSize(p.mmapBytesList)
+	ret.FreeChunksNum += len(p.freeFileChunks)
+	p.chunksMutex.Unlock()
+
+	return ret
 }
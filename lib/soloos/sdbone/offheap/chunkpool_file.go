@@ -0,0 +1,348 @@
+package offheap
+
+import (
+	"os"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	ChunkPoolFileMagic      = uint64(0x4c4f4f504b4e4843) // "CHNKPOOL"
+	ChunkPoolFileVersion    = uint32(1)
+	ChunkPoolFileHeaderSize = 4096
+)
+
+// chunkPoolFileHeader is saved at the beginning of a ChunkPool file
+// the file layout is:
+// [chunkPoolFileHeader, padding to ChunkPoolFileHeaderSize]
+// [mmapbytes 0, perMmapBytesSize]
+// [mmapbytes 1, perMmapBytesSize]
+// ...
+//
+// every mmapbytes begins with a bitmap of FreeChunksBitmapSize bytes, its chunks
+// follow. A bit is set for each chunk free at the last Sync, chunks never read
+// the bitmap, so chunks alloced after Sync do not change what is recovered.
+// MmapBytesNum and CurrentMmapBytesChunksNum are saved at Sync too.
+type chunkPoolFileHeader struct {
+	Magic                     uint64
+	Version                   uint32
+	ChunkStructSize           uint32
+	ChunkSize                 uint64
+	PerMmapBytesSize          uint64
+	FreeChunksBitmapSize      uint64
+	MmapBytesNum              uint64
+	CurrentMmapBytesChunksNum uint64
+	MaxChunkID                int64
+}
+
+// InitWithFile init a ChunkPool whose chunks are saved in the file at path.
+// If the file already holds a ChunkPool, the chunks alive at the last Sync or
+// Close are recovered and can be got by RecoveredChunks, otherwise the file is created.
+// Chunks recovered keep their ID and the content of their Data, but
+// prepareNewChunkFunc is only invoked for new chunks.
+func (p *ChunkPool) InitWithFile(id int64, path string,
+	chunkSize int, chunksLimit int32,
+	prepareNewChunkFunc ChunkPoolInvokePrepareNewChunk,
+	releaseChunkFunc ChunkPoolInvokeReleaseChunk) error {
+	var (
+		fileInfo os.FileInfo
+		err      error
+	)
+
+	// chunks carved into arenas would be neither alive nor free at Sync
+	p.options = PoolOptions{DisableArenas: true}
+	p.prepare(id, chunkSize, chunksLimit, prepareNewChunkFunc, releaseChunkFunc)
+	p.activeChunksNum = 0
+
+	p.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	fileInfo, err = p.file.Stat()
+	if err == nil {
+		if fileInfo.Size() == 0 {
+			err = p.createFile()
+		} else {
+			err = p.recoverFile(fileInfo.Size())
+		}
+	}
+
+	if err != nil {
+		for _, mmapBytes := range p.mmapBytesList {
+			FreeMmapBytes(mmapBytes)
+		}
+		p.mmapBytesList = nil
+		p.currentMmapBytes = nil
+		if p.fileHeader != nil {
+			FreeMmapBytes(&p.fileHeaderMmapBytes)
+			p.fileHeader = nil
+		}
+		p.file.Close()
+		p.file = nil
+		return err
+	}
+
+	return nil
+}
+
+func (p *ChunkPool) mmapFileHeader() error {
+	var err error
+	p.fileHeaderMmapBytes, err = AllocMmapBytesWithFile(p.file, 0, ChunkPoolFileHeaderSize)
+	if err != nil {
+		return err
+	}
+	p.fileHeader = (*chunkPoolFileHeader)(unsafe.Pointer(p.fileHeaderMmapBytes.addrBase()))
+	return nil
+}
+
+func (p *ChunkPool) createFile() error {
+	var (
+		pageSize = os.Getpagesize()
+		err      error
+	)
+
+	// mmap offset in file must be a multiple of the page size
+	p.perMmapBytesSize = (p.perMmapBytesSize + pageSize - 1) / pageSize * pageSize
+
+	err = p.file.Truncate(ChunkPoolFileHeaderSize)
+	if err != nil {
+		return err
+	}
+
+	err = p.mmapFileHeader()
+	if err != nil {
+		return err
+	}
+
+	p.fileHeader.Magic = ChunkPoolFileMagic
+	p.fileHeader.Version = ChunkPoolFileVersion
+	p.fileHeader.ChunkStructSize = uint32(ChunkStructSize)
+	p.fileHeader.ChunkSize = uint64(p.chunkSize)
+	p.fileHeader.PerMmapBytesSize = uint64(p.perMmapBytesSize)
+	// a bit for every chunk the mmapbytes could hold without bitmap, in words of 64 bits
+	p.fileHeader.FreeChunksBitmapSize = uint64((p.perMmapBytesSize/int(p.chunkWithStructSize)+63)/64) * 8
+
+	return p.growMmapBytesList()
+}
+
+func (p *ChunkPool) recoverFile(fileSize int64) error {
+	var (
+		uChunk       ChunkUintptr
+		chunksNum    int
+		mmapBytesNum int
+		pMmapBytes   *mmapbytes
+		freeChunks   Bitmap
+		i, k         int
+		err          error
+	)
+
+	if fileSize < ChunkPoolFileHeaderSize {
+		return ErrChunkPoolFileInvalid
+	}
+
+	err = p.mmapFileHeader()
+	if err != nil {
+		return err
+	}
+
+	if p.fileHeader.Magic != ChunkPoolFileMagic ||
+		p.fileHeader.Version != ChunkPoolFileVersion ||
+		p.fileHeader.ChunkStructSize != uint32(ChunkStructSize) ||
+		p.fileHeader.ChunkSize != uint64(p.chunkSize) ||
+		p.fileHeader.MmapBytesNum == 0 ||
+		p.fileHeader.FreeChunksBitmapSize%8 != 0 ||
+		p.fileHeader.FreeChunksBitmapSize >= p.fileHeader.PerMmapBytesSize ||
+		(p.fileHeader.PerMmapBytesSize-p.fileHeader.FreeChunksBitmapSize)/uint64(p.chunkWithStructSize) >
+			p.fileHeader.FreeChunksBitmapSize*8 ||
+		uint64(fileSize) < ChunkPoolFileHeaderSize+p.fileHeader.MmapBytesNum*p.fileHeader.PerMmapBytesSize {
+		return ErrChunkPoolFileInvalid
+	}
+
+	p.perMmapBytesSize = int(p.fileHeader.PerMmapBytesSize)
+	mmapBytesNum = int(p.fileHeader.MmapBytesNum)
+	for i = 0; i < mmapBytesNum; i++ {
+		pMmapBytes = new(mmapbytes)
+		*pMmapBytes, err = p.allocMmapBytesWithFile(i)
+		if err != nil {
+			return err
+		}
+		p.mmapBytesList = append(p.mmapBytesList, pMmapBytes)
+		p.currentMmapBytes = pMmapBytes
+		if i < mmapBytesNum-1 {
+			pMmapBytes.addrStart = pMmapBytes.addrEnd
+		} else {
+			pMmapBytes.addrStart += uintptr(p.fileHeader.CurrentMmapBytesChunksNum) * p.chunkWithStructSize
+			if pMmapBytes.addrStart > pMmapBytes.addrEnd {
+				return ErrChunkPoolFileInvalid
+			}
+		}
+	}
+
+	for _, pMmapBytes = range p.mmapBytesList {
+		freeChunks = p.fileFreeChunks(pMmapBytes)
+		chunksNum = p.fileChunksNum(pMmapBytes)
+		for k = 0; k < chunksNum; k++ {
+			uChunk = ChunkUintptr(p.fileChunksBase(pMmapBytes) + uintptr(k)*p.chunkWithStructSize)
+			// locks and accessors of the last process are meaningless
+			uChunk.Ptr().SharedPointer = SharedPointer{}
+			uChunk.Ptr().Data = uintptr(uChunk) + ChunkStructSize
+			if freeChunks.Has(int32(k)) {
+				p.freeFileChunks = append(p.freeFileChunks, uintptr(uChunk))
+				continue
+			}
+			p.recoveredChunks = append(p.recoveredChunks, uChunk)
+		}
+	}
+
+	p.activeChunksNum = int32(len(p.recoveredChunks))
+	p.maxChunkID = p.fileHeader.MaxChunkID
+
+	return nil
+}
+
+// allocMmapBytesWithFile maps the mmapbytes mmapBytesIndex of the file, the
+// file grows if the mmapbytes was not saved by Sync
+func (p *ChunkPool) allocMmapBytesWithFile(mmapBytesIndex int) (mmapbytes, error) {
+	var (
+		offset    = int64(ChunkPoolFileHeaderSize) + int64(mmapBytesIndex)*int64(p.perMmapBytesSize)
+		end       = offset + int64(p.perMmapBytesSize)
+		mmapBytes mmapbytes
+		err       error
+	)
+
+	if uint64(mmapBytesIndex) >= p.fileHeader.MmapBytesNum {
+		err = p.file.Truncate(end)
+		if err != nil {
+			return mmapbytes{}, err
+		}
+	}
+
+	mmapBytes, err = AllocMmapBytesWithFile(p.file, offset, p.perMmapBytesSize)
+	if err != nil {
+		return mmapbytes{}, err
+	}
+	mmapBytes.addrStart += uintptr(p.fileHeader.FreeChunksBitmapSize)
+
+	return mmapBytes, nil
+}
+
+// fileChunksBase returns the address of the first chunk of mmapBytes, after its bitmap
+func (p *ChunkPool) fileChunksBase(mmapBytes *mmapbytes) uintptr {
+	return mmapBytes.addrBase() + uintptr(p.fileHeader.FreeChunksBitmapSize)
+}
+
+// fileChunksNum returns how many chunks have been carved from mmapBytes
+func (p *ChunkPool) fileChunksNum(mmapBytes *mmapbytes) int {
+	var end = atomic.LoadUintptr(&mmapBytes.addrStart)
+	if end > mmapBytes.addrEnd {
+		end = mmapBytes.addrEnd
+	}
+	return int((end - p.fileChunksBase(mmapBytes)) / p.chunkWithStructSize)
+}
+
+// fileFreeChunks returns the bitmap of the chunks of mmapBytes free at the last Sync
+func (p *ChunkPool) fileFreeChunks(mmapBytes *mmapbytes) Bitmap {
+	return Bitmap{words: unsafe.Slice((*uint64)(unsafe.Pointer(mmapBytes.addrBase())),
+		p.fileHeader.FreeChunksBitmapSize/8)}
+}
+
+// popFileFreeChunk returns a free chunk of the file, or 0
+func (p *ChunkPool) popFileFreeChunk() uintptr {
+	var ret uintptr
+
+	p.chunksMutex.Lock()
+	if last := len(p.freeFileChunks) - 1; last >= 0 {
+		ret = p.freeFileChunks[last]
+		p.freeFileChunks = p.freeFileChunks[:last]
+	}
+	p.chunksMutex.Unlock()
+
+	return ret
+}
+
+func (p *ChunkPool) putFileFreeChunk(uChunk uintptr) {
+	p.chunksMutex.Lock()
+	p.freeFileChunks = append(p.freeFileChunks, uChunk)
+	p.chunksMutex.Unlock()
+}
+
+// RecoveredChunks returns the chunks recovered from file by InitWithFile
+func (p *ChunkPool) RecoveredChunks() []ChunkUintptr {
+	return p.recoveredChunks
+}
+
+// Sync saves the ChunkPool inited by InitWithFile to its file, chunks alive
+// at Sync are recovered when the file is opened again.
+func (p *ChunkPool) Sync() error {
+	var (
+		freeChunks          []uintptr
+		sortedMmapBytesList []*mmapbytes
+		freeChunksBitmap    Bitmap
+		mmapBytesIndex      int
+		i                   int
+		err                 error
+	)
+
+	if p.file == nil {
+		return nil
+	}
+
+	p.chunksMutex.Lock()
+
+	sortedMmapBytesList = make([]*mmapbytes, len(p.mmapBytesList))
+	copy(sortedMmapBytesList, p.mmapBytesList)
+	sortMmapBytesList(sortedMmapBytesList)
+	for _, mmapBytes := range p.mmapBytesList {
+		freeChunksBitmap = p.fileFreeChunks(mmapBytes)
+		freeChunksBitmap.Reset()
+	}
+
+	freeChunks = p.freeFileChunks
+	for i = 0; i < len(freeChunks); i++ {
+		mmapBytesIndex = searchMmapBytesList(sortedMmapBytesList, freeChunks[i])
+		if mmapBytesIndex < 0 {
+			continue
+		}
+		freeChunksBitmap = p.fileFreeChunks(sortedMmapBytesList[mmapBytesIndex])
+		freeChunksBitmap.UnsafeSet(int32((freeChunks[i] - p.fileChunksBase(sortedMmapBytesList[mmapBytesIndex])) /
+			p.chunkWithStructSize))
+	}
+
+	for _, mmapBytes := range p.mmapBytesList {
+		if err == nil {
+			err = SyncMmapBytes(mmapBytes)
+		}
+	}
+
+	// the header is saved once the chunks and bitmaps are
+	if err == nil {
+		p.fileHeader.MmapBytesNum = uint64(len(p.mmapBytesList))
+		p.fileHeader.MaxChunkID = atomic.LoadInt64(&p.maxChunkID)
+		p.fileHeader.CurrentMmapBytesChunksNum = uint64(p.fileChunksNum(p.currentMmapBytes))
+		err = SyncMmapBytes(&p.fileHeaderMmapBytes)
+	}
+
+	p.chunksMutex.Unlock()
+
+	return err
+}
+
+func (p *ChunkPool) closeFile() error {
+	var err error
+
+	err = p.Sync()
+
+	p.chunksMutex.Lock()
+	if freeErr := FreeMmapBytes(&p.fileHeaderMmapBytes); freeErr != nil && err == nil {
+		err = freeErr
+	}
+	p.fileHeader = nil
+	if closeErr := p.file.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	p.file = nil
+	p.chunksMutex.Unlock()
+
+	return err
+}
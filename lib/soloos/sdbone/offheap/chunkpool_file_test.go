@@ -0,0 +1,125 @@
+package offheap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkPoolWithFile(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		chunkPool     ChunkPool
+		uChunks       []ChunkUintptr
+		chunkIDs      = make(map[int64]byte)
+		chunkSize     = 64
+		chunksNum     = 3000
+	)
+
+	dir, err := ioutil.TempDir("", "chunkpool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chunkpool")
+
+	assert.NoError(t, offheapDriver.Init())
+	assert.NoError(t, offheapDriver.InitChunkPoolWithFile(&chunkPool, path, chunkSize, -1, nil, nil))
+	assert.Equal(t, 0, len(chunkPool.RecoveredChunks()))
+
+	for i := 0; i < chunksNum; i++ {
+		uChunk := chunkPool.AllocChunk()
+		(*[64]byte)(unsafe.Pointer(uChunk.Ptr().Data))[0] = byte(i)
+		uChunks = append(uChunks, uChunk)
+	}
+	for i := 0; i < chunksNum; i += 2 {
+		chunkPool.ReleaseChunk(uintptr(uChunks[i]))
+	}
+	for i := 1; i < chunksNum; i += 2 {
+		chunkIDs[uChunks[i].Ptr().ID] = byte(i)
+	}
+	assert.NoError(t, chunkPool.Close())
+
+	assert.NoError(t, offheapDriver.InitChunkPoolWithFile(&chunkPool, path, chunkSize, -1, nil, nil))
+	assert.Equal(t, len(chunkIDs), len(chunkPool.RecoveredChunks()))
+	for _, uChunk := range chunkPool.RecoveredChunks() {
+		data, exists := chunkIDs[uChunk.Ptr().ID]
+		assert.True(t, exists)
+		assert.Equal(t, data, (*[64]byte)(unsafe.Pointer(uChunk.Ptr().Data))[0])
+		delete(chunkIDs, uChunk.Ptr().ID)
+	}
+
+	uChunk := chunkPool.AllocChunk()
+	assert.Equal(t, uintptr(uChunk)+ChunkStructSize, uChunk.Ptr().Data)
+	assert.True(t, uChunk.Ptr().ID <= int64(chunksNum))
+	assert.NoError(t, chunkPool.Close())
+
+	assert.Equal(t, ErrChunkPoolFileInvalid,
+		offheapDriver.InitChunkPoolWithFile(&chunkPool, path, chunkSize*2, -1, nil, nil))
+}
+
+// crashChunkPoolWithFile unmaps chunkPool and closes its file without Sync,
+// like a process dying
+func crashChunkPoolWithFile(chunkPool *ChunkPool) {
+	for _, mmapBytes := range chunkPool.mmapBytesList {
+		FreeMmapBytes(mmapBytes)
+	}
+	FreeMmapBytes(&chunkPool.fileHeaderMmapBytes)
+	chunkPool.file.Close()
+	*chunkPool = ChunkPool{}
+}
+
+func TestChunkPoolWithFileRecoverWithoutSync(t *testing.T) {
+	var (
+		chunkPool ChunkPool
+		uChunks   []ChunkUintptr
+		chunkIDs  = make(map[int64]byte)
+		chunkSize = 64
+		chunksNum = 100
+	)
+
+	dir, err := ioutil.TempDir("", "chunkpool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chunkpool")
+
+	assert.NoError(t, chunkPool.InitWithFile(0, path, chunkSize, -1, nil, nil))
+	for i := 0; i < chunksNum; i++ {
+		uChunk := chunkPool.AllocChunk()
+		(*[64]byte)(unsafe.Pointer(uChunk.Ptr().Data))[0] = byte(i)
+		uChunks = append(uChunks, uChunk)
+	}
+	for i := 0; i < chunksNum; i += 2 {
+		chunkPool.ReleaseChunk(uintptr(uChunks[i]))
+	}
+	for i := 1; i < chunksNum; i += 2 {
+		chunkIDs[uChunks[i].Ptr().ID] = byte(i)
+	}
+	assert.NoError(t, chunkPool.Sync())
+
+	// chunks free at Sync are reused and new mmapbytes are added, then the
+	// process dies before the next Sync
+	for i := 0; i < 2000; i++ {
+		uChunk := chunkPool.AllocChunk()
+		(*[64]byte)(unsafe.Pointer(uChunk.Ptr().Data))[0] = 0xff
+	}
+	chunkPool.ReleaseChunk(uintptr(uChunks[1]))
+	crashChunkPoolWithFile(&chunkPool)
+
+	assert.NoError(t, chunkPool.InitWithFile(0, path, chunkSize, -1, nil, nil))
+	assert.Equal(t, len(chunkIDs), len(chunkPool.RecoveredChunks()))
+	for _, uChunk := range chunkPool.RecoveredChunks() {
+		data, exists := chunkIDs[uChunk.Ptr().ID]
+		assert.True(t, exists)
+		assert.Equal(t, data, (*[64]byte)(unsafe.Pointer(uChunk.Ptr().Data))[0])
+		delete(chunkIDs, uChunk.Ptr().ID)
+	}
+	assert.Equal(t, 1, chunkPool.Stats().MmapBytesNum)
+	assert.Equal(t, chunksNum/2, chunkPool.Stats().FreeChunksNum)
+
+	uChunk := chunkPool.AllocChunk()
+	assert.Equal(t, uintptr(uChunk)+ChunkStructSize, uChunk.Ptr().Data)
+	assert.NoError(t, chunkPool.Close())
+}
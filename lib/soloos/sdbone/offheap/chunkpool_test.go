@@ -1,20 +1,26 @@
 package offheap
 
 import (
+	"context"
+	"runtime"
 	"soloos/common/util"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 )
 
 type MockChunkPool struct {
 	driver        *MockOffheapDriver
-	chunks        map[int32]ChunkUintptr
+	chunks        map[int64]ChunkUintptr
 	offheapDriver *OffheapDriver
 	chunkPool     ChunkPool
 }
 
-func (p *MockChunkPool) Init(chunks map[int32]ChunkUintptr, offheapDriver *OffheapDriver,
+func (p *MockChunkPool) Init(chunks map[int64]ChunkUintptr, offheapDriver *OffheapDriver,
 	chunkSize int, chunksLimit int32) error {
 	var err error
 	p.chunks = chunks
@@ -36,14 +42,14 @@ func (p *MockChunkPool) takeChunkForRelease() ChunkUintptr {
 	return 0x00
 }
 
-func (p *MockChunkPool) ChunkPoolInvokePrepareNewChunk(uChunk ChunkUintptr) {
+func (p *MockChunkPool) ChunkPoolInvokePrepareNewChunk(uChunk uintptr) {
 }
 
 func (p *MockChunkPool) ChunkPoolInvokeReleaseChunk() {
 	uChunk := p.takeChunkForRelease()
 	pChunk := uChunk.Ptr()
 	delete(p.chunks, pChunk.ID)
-	p.chunkPool.ReleaseChunk(uChunk)
+	p.chunkPool.ReleaseChunk(uintptr(uChunk))
 	return
 }
 
@@ -60,12 +66,33 @@ func BenchmarkChunkPool(b *testing.B) {
 	)
 
 	util.AssertErrIsNil(offheapDriver.Init())
-	util.AssertErrIsNil(mockChunkPool.Init(make(map[int32]ChunkUintptr), &offheapDriver, 10, 1024))
+	util.AssertErrIsNil(mockChunkPool.Init(make(map[int64]ChunkUintptr), &offheapDriver, 10, 1024))
 	for n := 0; n < b.N; n++ {
 		mockChunkPool.AllocChunk()
 	}
 }
 
+func benchmarkChunkPoolMallocParallel(b *testing.B, options PoolOptions) {
+	var chunkPool ChunkPool
+	util.AssertErrIsNil(chunkPool.Init(1, 64, -1, nil, nil, options))
+	defer chunkPool.Close()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			chunkPool.mallocChunk()
+		}
+	})
+}
+
+func BenchmarkChunkPoolMallocParallel(b *testing.B) {
+	benchmarkChunkPoolMallocParallel(b, PoolOptions{})
+}
+
+func BenchmarkChunkPoolMallocParallelWithoutArenas(b *testing.B) {
+	benchmarkChunkPoolMallocParallel(b, PoolOptions{DisableArenas: true})
+}
+
 func TestChunkPool(t *testing.T) {
 	var (
 		offheapDriver OffheapDriver
@@ -74,9 +101,199 @@ func TestChunkPool(t *testing.T) {
 	)
 
 	assert.NoError(t, offheapDriver.Init())
-	assert.NoError(t, mockChunkPool.Init(make(map[int32]ChunkUintptr), &offheapDriver, 1024, 1024))
+	assert.NoError(t, mockChunkPool.Init(make(map[int64]ChunkUintptr), &offheapDriver, 1024, 1024))
 	uChunk = mockChunkPool.chunkPool.AllocChunk()
 	assert.NotNil(t, uChunk)
 
-	mockChunkPool.chunkPool.ReleaseChunk(uChunk)
+	mockChunkPool.chunkPool.ReleaseChunk(uintptr(uChunk))
+}
+
+func TestChunkPoolShrinkAndClose(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		mockChunkPool MockChunkPool
+		uChunks       []ChunkUintptr
+		releasedSize  int
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	assert.NoError(t, mockChunkPool.Init(make(map[int64]ChunkUintptr), &offheapDriver, 1024, -1))
+	chunkPool := &mockChunkPool.chunkPool
+	chunksNumPerMmapBytes := chunkPool.perMmapBytesSize / int(chunkPool.chunkWithStructSize)
+
+	for i := 0; i < chunksNumPerMmapBytes*2+1; i++ {
+		uChunks = append(uChunks, chunkPool.AllocChunk())
+	}
+	assert.Equal(t, 3, len(chunkPool.mmapBytesList))
+
+	releasedSize, err = chunkPool.Shrink()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, releasedSize)
+	// chunks left in arenas are not prepared by Shrink
+	assert.Equal(t, int64(len(uChunks)), chunkPool.maxChunkID)
+
+	for _, uChunk := range uChunks {
+		chunkPool.ReleaseChunk(uintptr(uChunk))
+	}
+	releasedSize, err = chunkPool.Shrink()
+	assert.NoError(t, err)
+	// NoGCUintptrPool drops chunks under race detector, their regions are kept
+	if runtime.UnsafeRaceEnabled == false {
+		assert.Equal(t, chunkPool.perMmapBytesSize*2, releasedSize)
+		assert.Equal(t, 1, len(chunkPool.mmapBytesList))
+	}
+	assert.Equal(t, int64(len(uChunks)), chunkPool.maxChunkID)
+
+	uChunk := chunkPool.AllocChunk()
+	assert.Equal(t, uintptr(uChunk)+ChunkStructSize, uChunk.Ptr().Data)
+	chunkPool.ReleaseChunk(uintptr(uChunk))
+
+	assert.NotNil(t, offheapDriver.GetChunkPool(chunkPool.ID))
+	assert.NoError(t, chunkPool.Close())
+	assert.Nil(t, offheapDriver.GetChunkPool(chunkPool.ID))
+	assert.Equal(t, 0, len(chunkPool.mmapBytesList))
+}
+
+func TestChunkPoolTryAllocChunk(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		chunkPool     ChunkPool
+		uChunk        ChunkUintptr
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	assert.NoError(t, offheapDriver.InitChunkPool(&chunkPool, 1024, 2, nil, nil))
+
+	uChunk, err = chunkPool.TryAllocChunk()
+	assert.NoError(t, err)
+	assert.NotEqual(t, ChunkUintptr(0), uChunk)
+	_, err = chunkPool.TryAllocChunk()
+	assert.NoError(t, err)
+
+	_, err = chunkPool.TryAllocChunk()
+	assert.Equal(t, ErrAllocChunkOurOfLimit, err)
+
+	chunkPool.ReleaseChunk(uintptr(uChunk))
+	uChunk, err = chunkPool.TryAllocChunk()
+	assert.NoError(t, err)
+	assert.NotEqual(t, ChunkUintptr(0), uChunk)
+}
+
+func TestChunkPoolTryAllocChunkConcurrent(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		chunkPool     ChunkPool
+		allocedChan   = make(chan ChunkUintptr, 16)
+		errsNum       int32
+		waitGroup     sync.WaitGroup
+	)
+
+	// goroutines hold at most 8 of the 16 chunks, so a chunk can always be released
+	assert.NoError(t, offheapDriver.Init())
+	assert.NoError(t, offheapDriver.InitChunkPool(&chunkPool, 64, 16, nil, func() {
+		select {
+		case uChunk := <-allocedChan:
+			chunkPool.ReleaseChunk(uintptr(uChunk))
+			// lets another allocator take the released chunk first
+			runtime.Gosched()
+		default:
+		}
+	}))
+
+	for i := 0; i < 8; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for n := 0; n < 20000; n++ {
+				uChunk, err := chunkPool.TryAllocChunk()
+				if err != nil {
+					atomic.AddInt32(&errsNum, 1)
+					continue
+				}
+				allocedChan <- uChunk
+			}
+		}()
+	}
+	waitGroup.Wait()
+	assert.Equal(t, int32(0), errsNum)
+}
+
+func TestChunkPoolAllocChunkCtx(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		chunkPool     ChunkPool
+		uChunk        ChunkUintptr
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	assert.NoError(t, offheapDriver.InitChunkPool(&chunkPool, 1024, 1, nil, nil))
+
+	uChunk, err = chunkPool.AllocChunkCtx(context.Background())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	_, err = chunkPool.AllocChunkCtx(ctx)
+	cancel()
+	assert.Equal(t, ErrAllocChunkOurOfLimit, err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = chunkPool.AllocChunkCtx(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	allocedChan := make(chan ChunkUintptr)
+	go func() {
+		allocedChan <- chunkPool.AllocChunk()
+	}()
+	for chunkPool.Stats().WaitersNum == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	chunkPool.ReleaseChunk(uintptr(uChunk))
+	assert.NotEqual(t, ChunkUintptr(0), <-allocedChan)
+	assert.Equal(t, int32(0), chunkPool.Stats().WaitersNum)
+	assert.Equal(t, int32(1), chunkPool.Stats().ActiveChunksNum)
+}
+
+func TestChunkPoolWithOptions(t *testing.T) {
+	var (
+		offheapDriver       OffheapDriver
+		chunkPool           ChunkPool
+		preparedChunksNum   int
+		uChunks             []ChunkUintptr
+		chunkSize           = 4096 * 4
+		prepareNewChunkFunc = func(uChunk uintptr) { preparedChunksNum++ }
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	assert.NoError(t, offheapDriver.InitChunkPool(&chunkPool, chunkSize, -1,
+		prepareNewChunkFunc, nil,
+		PoolOptions{HugePage: MmapHugePageHugeTLB, FreeChunkIdleDuration: time.Hour}))
+	assert.Equal(t, 0, chunkPool.perMmapBytesSize%HugePageSize)
+
+	for i := 0; i < 8; i++ {
+		uChunk := chunkPool.AllocChunk()
+		(*[1]byte)(unsafe.Pointer(uChunk.Ptr().Data))[0] = 1
+		uChunks = append(uChunks, uChunk)
+	}
+	assert.Equal(t, 8, preparedChunksNum)
+	for _, uChunk := range uChunks {
+		chunkPool.ReleaseChunk(uintptr(uChunk))
+	}
+
+	assert.Equal(t, 0, chunkPool.AdviseIdleChunks())
+	// NoGCUintptrPool drops chunks under race detector, they are never advised
+	if runtime.UnsafeRaceEnabled {
+		assert.True(t, chunkPool.AdviseIdleChunks() > 0)
+	} else {
+		assert.True(t, chunkPool.AdviseIdleChunks() >= 8*(chunkSize-4096))
+	}
+
+	uChunk := chunkPool.AllocChunk()
+	assert.Equal(t, 9, preparedChunksNum)
+	assert.Equal(t, uintptr(uChunk)+ChunkStructSize, uChunk.Ptr().Data)
+
+	assert.NoError(t, chunkPool.Close())
 }
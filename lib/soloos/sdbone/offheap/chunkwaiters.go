@@ -0,0 +1,87 @@
+package offheap
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// chunkWaiters are the goroutines of a pool waiting for a chunk to be
+// released while the chunks limit is reached
+type chunkWaiters struct {
+	waitersNum  int32
+	mutex       sync.Mutex
+	releaseChan chan struct{}
+}
+
+// reserve reserves a chunk by reserveChunkFunc. While the chunks limit is
+// reached, it invokes releaseChunkFunc, and waits for a chunk to be released
+// if releaseChunkFunc does not release any, that is if releasedChunksNumFunc
+// does not grow.
+// It returns ErrAllocChunkOurOfLimit if the deadline of ctx is exceeded, and
+// ctx.Err() if ctx is canceled.
+func (p *chunkWaiters) reserve(ctx context.Context, releasedChunksNumFunc func() int64,
+	reserveChunkFunc func() bool, releaseChunkFunc func()) error {
+	var (
+		releaseChan           <-chan struct{}
+		lastReleasedChunksNum int64
+	)
+
+	for reserveChunkFunc() == false {
+		if releaseChunkFunc != nil {
+			lastReleasedChunksNum = releasedChunksNumFunc()
+			releaseChunkFunc()
+			if releasedChunksNumFunc() != lastReleasedChunksNum {
+				// another allocator may take the released chunk first
+				continue
+			}
+		}
+
+		atomic.AddInt32(&p.waitersNum, 1)
+		p.mutex.Lock()
+		if p.releaseChan == nil {
+			p.releaseChan = make(chan struct{})
+		}
+		releaseChan = p.releaseChan
+		p.mutex.Unlock()
+
+		// a chunk may be released before waitersNum is increased
+		if reserveChunkFunc() {
+			atomic.AddInt32(&p.waitersNum, -1)
+			return nil
+		}
+
+		select {
+		case <-releaseChan:
+		case <-ctx.Done():
+		}
+		atomic.AddInt32(&p.waitersNum, -1)
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return ErrAllocChunkOurOfLimit
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// broadcast wakes up every waiter after a chunk is released
+func (p *chunkWaiters) broadcast() {
+	if atomic.LoadInt32(&p.waitersNum) == 0 {
+		return
+	}
+
+	p.mutex.Lock()
+	if p.releaseChan != nil {
+		close(p.releaseChan)
+		p.releaseChan = nil
+	}
+	p.mutex.Unlock()
+}
+
+func (p *chunkWaiters) len() int32 {
+	return atomic.LoadInt32(&p.waitersNum)
+}
@@ -6,4 +6,20 @@ var (
 	ErrUnknownKeyType       = errors.New("unknown keytype")
 	ErrAllocChunkOurOfLimit = errors.New("alloc chunk out of limit")
 	ErrMmap                 = errors.New("mmap error")
+	ErrChunkPoolFileInvalid = errors.New("chunk pool file invalid")
+	ErrMallocInvalidPointer = errors.New("malloc invalid pointer")
+	ErrMallocInvalidSize    = errors.New("malloc invalid size")
+	ErrChunkInvalidRelease  = errors.New("release chunk not alloced")
+	ErrChunkDoubleRelease   = errors.New("chunk double release")
+	ErrChunkOverflow        = errors.New("chunk overflow")
+	ErrChunkUseAfterRelease = errors.New("chunk use after release")
+
+	ErrHKVTableSnapshotInvalid  = errors.New("hkvtable snapshot invalid")
+	ErrHKVTableSnapshotChecksum = errors.New("hkvtable snapshot checksum mismatch")
+	ErrTableNameCollision       = errors.New("table name collision")
+	ErrTableNotFound            = errors.New("table not found")
+	ErrSharedCountInvalid       = errors.New("shared count invalid")
+	ErrObjectSizeInvalid        = errors.New("object size invalid")
+	ErrKeyTooLong               = errors.New("key too long")
+	ErrValueTooLong             = errors.New("value too long")
 )
@@ -0,0 +1,570 @@
+package offheap
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+type HKVTableObjectUPtr[K comparable] uintptr
+
+func (u HKVTableObjectUPtr[K]) Ptr() *HKVTableObject[K] {
+	return (*HKVTableObject[K])(unsafe.Pointer(u))
+}
+
+type HKVTableObject[K comparable] struct {
+	HKVTableEvictionMeta
+	// ExpireAt is the deadline of the object in unix nanoseconds, 0 if none
+	ExpireAt int64
+	// Version is increased by every write of the value of the object, versions
+	// are unique in the HKVTable so that a key alloced again never reuses one
+	Version uint64
+	value   OBytes
+	ID      K
+	HSharedPointer
+}
+
+// Heavy Key-Value table
+type HKVTable[K comparable] struct {
+	HKVTableCommon
+	hasher  HKVTableHasher[K]
+	shareds atomic.Pointer[hkvTableShareds[K]]
+	// maxVersion is the last version given to an object
+	maxVersion uint64
+
+	reshardMutex sync.Mutex
+	reshardGate  hkvTableReshardGate
+}
+
+// CreateHKVTable creates a HKVTable with keys of type K registered as name in
+// offheapDriver, the default hasher of K is used if hasher is nil.
+// It returns ErrTableNameCollision if offheapDriver has a table named name.
+func CreateHKVTable[K comparable](offheapDriver *OffheapDriver, name string,
+	objectSize int, objectsLimit int32, sharedCount uint32,
+	hasher HKVTableHasher[K],
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+	options ...HKVTableOptions,
+) (*HKVTable[K], error) {
+	var (
+		kvTable = new(HKVTable[K])
+		err     error
+	)
+	err = offheapDriver.reserveTable(name)
+	if err != nil {
+		return nil, err
+	}
+
+	kvTable.offheapDriver = offheapDriver
+	err = kvTable.InitWithHasher(name, objectSize, objectsLimit, sharedCount,
+		hasher,
+		prepareNewObjectFunc,
+		beforeReleaseObjectFunc,
+		options...,
+	)
+	if err != nil {
+		offheapDriver.setTable(name, nil)
+		return nil, err
+	}
+	offheapDriver.setTable(name, kvTable)
+
+	return kvTable, err
+}
+
+func (p *HKVTable[K]) Init(name string,
+	objectSize int, objectsLimit int32, sharedCount uint32,
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+	options ...HKVTableOptions,
+) error {
+	return p.InitWithHasher(name, objectSize, objectsLimit, sharedCount,
+		nil,
+		prepareNewObjectFunc,
+		beforeReleaseObjectFunc,
+		options...,
+	)
+}
+
+// InitWithHasher is Init with the hasher sharding keys,
+// the default hasher of K is used if hasher is nil.
+// It returns ErrUnknownKeyType if hasher is nil and K has no default hasher,
+// ErrObjectSizeInvalid if objectSize can not hold a HKVTableObject[K]
+func (p *HKVTable[K]) InitWithHasher(name string,
+	objectSize int, objectsLimit int32, sharedCount uint32,
+	hasher HKVTableHasher[K],
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+	options ...HKVTableOptions,
+) error {
+	var (
+		hkvTableOptions = getHKVTableOptions(options)
+		err             error
+	)
+
+	if hasher == nil {
+		if hkvTableOptions.HashSeed != 0 {
+			hasher = DefaultHKVTableHasherWithSeed[K](hkvTableOptions.HashSeed)
+		} else {
+			hasher = DefaultHKVTableHasher[K]()
+		}
+		if hasher == nil {
+			return ErrUnknownKeyType
+		}
+	}
+
+	if objectSize < int(unsafe.Sizeof(HKVTableObject[K]{})) {
+		return ErrObjectSizeInvalid
+	}
+
+	if sharedCount == 0 {
+		return ErrSharedCountInvalid
+	}
+
+	if hkvTableOptions.Index == HKVTableIndexOffheap &&
+		isPointerFreeType(reflect.TypeOf((*K)(nil)).Elem()) == false {
+		return ErrUnknownKeyType
+	}
+
+	p.name = name
+	p.objectSize = objectSize
+	p.objectsLimit = objectsLimit
+	p.hasher = hasher
+	p.evictionPolicy = hkvTableOptions.EvictionPolicy
+	p.evictionPolicy.Init(objectsLimit)
+	p.indexType = hkvTableOptions.Index
+	p.memoryBudget = hkvTableOptions.MemoryBudget
+
+	p.reshardGate.init()
+
+	err = p.prepareShareds(sharedCount)
+	if err != nil {
+		return err
+	}
+
+	p.prepareNewObjectFunc = prepareNewObjectFunc
+	p.beforeReleaseObjectFunc = beforeReleaseObjectFunc
+
+	if hkvTableOptions.ExpireSweepInterval > 0 {
+		p.startExpireSweeper(hkvTableOptions.ExpireSweepInterval)
+	}
+
+	return nil
+}
+
+func (p *HKVTable[K]) Name() string {
+	return p.name
+}
+
+// getShared returns the index of the current shared of objKey
+func (p *HKVTable[K]) getShared(objKey K) int {
+	return p.loadShareds().index(p.hasher(objKey))
+}
+
+func (p *HKVTable[K]) prepareShareds(sharedCount uint32) error {
+	var (
+		shareds *hkvTableShareds[K]
+		err     error
+	)
+	shareds, err = p.newShareds(sharedCount)
+	if err != nil {
+		return err
+	}
+	p.shareds.Store(shareds)
+
+	err = p.initChunkPool(p.chunkPoolInvokePrepareNewChunk,
+		p.chunkPoolInvokeReleaseChunk)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Stats returns a snapshot of the statistics of the HKVTable
+func (p *HKVTable[K]) Stats() HKVTableStats {
+	var ret HKVTableStats
+
+	ret.Name = p.name
+	p.reshardGate.beginScan()
+	for _, shareds := range p.sharedsChain() {
+		for sharedIndex := range shareds.shareds {
+			shareds.rwMutexs[sharedIndex].RLock()
+			if shareds.migrated[sharedIndex] == false {
+				ret.ObjectsNum += shareds.shareds[sharedIndex].len()
+			}
+			shareds.rwMutexs[sharedIndex].RUnlock()
+		}
+	}
+	p.reshardGate.endScan()
+	ret.PoolStats = p.chunkPool.Stats()
+
+	return ret
+}
+
+func (p *HKVTable[K]) chunkPoolInvokePrepareNewChunk(uChunk uintptr) {
+	if p.prepareNewObjectFunc != nil {
+		p.prepareNewObjectFunc(uChunk)
+	}
+}
+
+func (p *HKVTable[K]) isObjectEvictable(uObject uintptr) bool {
+	return HKVTableObjectUPtr[K](uObject).Ptr().GetAccessor() == 0
+}
+
+// chunkPoolInvokeReleaseChunk deletes an object not acquired, if every object
+// is acquired nothing is released and the alloc fails with ErrAllocChunkOurOfLimit,
+// waiting for one could deadlock as the allocating goroutine may hold them
+func (p *HKVTable[K]) chunkPoolInvokeReleaseChunk() {
+	var (
+		uReleaseTarget uintptr
+		releaseKey     K
+	)
+
+	// the key is copied while the eviction policy keeps the victim from
+	// OnDelete, after Victim the object may be deleted and its chunk reused
+	uReleaseTarget = p.evictionPolicy.Victim(func(uObject uintptr) bool {
+		if p.isObjectEvictable(uObject) == false {
+			return false
+		}
+		releaseKey = HKVTableObjectUPtr[K](uObject).Ptr().ID
+		return true
+	})
+
+	if uReleaseTarget != 0 {
+		p.DeleteObject(releaseKey)
+	}
+}
+
+func (p *HKVTable[K]) allocObjectWithAcquire(objKey K, isWrite bool) (HKVTableObjectUPtr[K], error) {
+	uRawChunk, err := p.chunkPool.TryAllocRawChunk()
+	if err != nil {
+		return 0, err
+	}
+
+	var uObject = HKVTableObjectUPtr[K](uRawChunk)
+	p.acquireObject(uObject, isWrite)
+	uObject.Ptr().ExpireAt = 0
+	uObject.Ptr().Version = p.nextVersion()
+	uObject.Ptr().value = OBytes{}
+	uObject.Ptr().ID = objKey
+	uObject.Ptr().CompleteInit()
+	return uObject, nil
+}
+
+// acquireObject write acquires v if isWrite, read acquires it otherwise
+func (p *HKVTable[K]) acquireObject(v HKVTableObjectUPtr[K], isWrite bool) {
+	if isWrite {
+		v.Ptr().WriteAcquire()
+	} else {
+		v.Ptr().ReadAcquire()
+	}
+}
+
+func (p *HKVTable[K]) releaseObject(v HKVTableObjectUPtr[K], isWrite bool) {
+	if isWrite {
+		v.Ptr().WriteRelease()
+	} else {
+		v.Ptr().ReadRelease()
+	}
+}
+
+func (p *HKVTable[K]) checkObject(v HKVTableObjectUPtr[K], objKey K) bool {
+	return v.Ptr().ID == objKey && v.Ptr().IsInited()
+}
+
+// isObjectExpired is true if the deadline of the object is passed,
+// expired objects are deleted by lookups and the expire sweeper
+func (p *HKVTable[K]) isObjectExpired(v HKVTableObjectUPtr[K]) bool {
+	expireAt := atomic.LoadInt64(&v.Ptr().ExpireAt)
+	return expireAt != 0 && expireAt <= time.Now().UnixNano()
+}
+
+// MustGetObjectWithReadAcquire get or init an object
+// The bool result is true if the object was loaded, false if alloc.
+// The error result is ErrMmap or ErrAllocChunkOurOfLimit if the object could not be alloc.
+func (p *HKVTable[K]) MustGetObjectWithReadAcquire(objKey K) (uintptr, bool, error) {
+	return p.mustGetObjectWithAcquire(objKey, 0, false)
+}
+
+// MustGetObjectWithWriteAcquire is MustGetObjectWithReadAcquire with the object
+// write acquired, to be released by WriteRelease
+func (p *HKVTable[K]) MustGetObjectWithWriteAcquire(objKey K) (uintptr, bool, error) {
+	return p.mustGetObjectWithAcquire(objKey, 0, true)
+}
+
+// MustGetObjectWithReadAcquireTTL is MustGetObjectWithReadAcquire expiring
+// the object ttl later if it is alloc. The deadline of a loaded object is kept.
+func (p *HKVTable[K]) MustGetObjectWithReadAcquireTTL(objKey K, ttl time.Duration) (uintptr, bool, error) {
+	return p.mustGetObjectWithAcquire(objKey, ttl, false)
+}
+
+func (p *HKVTable[K]) mustGetObjectWithAcquire(objKey K, ttl time.Duration, isWrite bool) (uintptr, bool, error) {
+	var (
+		uObject       HKVTableObjectUPtr[K] = 0
+		hash          uint64
+		shared        *hkvTableShared[K]
+		sharedRWMutex *sync.RWMutex
+		loaded        bool = false
+	)
+
+	hash = p.hasher(objKey)
+
+	shared, sharedRWMutex = p.lockShared(hash, false)
+	uObject, loaded = shared.get(objKey, hash)
+	sharedRWMutex.RUnlock()
+
+	if uObject != 0 {
+		p.acquireObject(uObject, isWrite)
+		if p.checkObject(uObject, objKey) == false {
+			p.releaseObject(uObject, isWrite)
+			uObject = 0
+			loaded = false
+		} else if p.isObjectExpired(uObject) {
+			p.releaseObject(uObject, isWrite)
+			p.deleteObject(objKey, true)
+			uObject = 0
+			loaded = false
+		} else {
+			loaded = true
+			p.evictionPolicy.OnAccess(uintptr(uObject))
+		}
+	}
+
+	if uObject != 0 {
+		return uintptr(uObject), loaded, nil
+	}
+
+	var (
+		uNewObject        HKVTableObjectUPtr[K]
+		isNewObjectSetted bool = false
+		err               error
+	)
+
+	uNewObject, err = p.allocObjectWithAcquire(objKey, isWrite)
+	if err != nil {
+		return 0, false, err
+	}
+	if ttl > 0 {
+		uNewObject.Ptr().ExpireAt = time.Now().Add(ttl).UnixNano()
+	}
+	p.evictionPolicy.OnInsert(uintptr(uNewObject))
+
+	for isNewObjectSetted == false && loaded == false {
+		shared, sharedRWMutex = p.lockShared(hash, true)
+		uObject, loaded = shared.get(objKey, hash)
+		if uObject == 0 {
+			err = shared.set(objKey, hash, uNewObject)
+			if err != nil {
+				sharedRWMutex.Unlock()
+				break
+			}
+			uObject = uNewObject
+			isNewObjectSetted = true
+		}
+		sharedRWMutex.Unlock()
+
+		if isNewObjectSetted == false {
+			p.acquireObject(uObject, isWrite)
+			if p.checkObject(uObject, objKey) == false {
+				p.releaseObject(uObject, isWrite)
+				uObject = 0
+				loaded = false
+			} else if p.isObjectExpired(uObject) {
+				p.releaseObject(uObject, isWrite)
+				p.deleteObject(objKey, true)
+				uObject = 0
+				loaded = false
+			} else {
+				loaded = true
+				p.evictionPolicy.OnAccess(uintptr(uObject))
+			}
+		}
+	}
+
+	if isNewObjectSetted == false {
+		p.evictionPolicy.OnDelete(uintptr(uNewObject))
+		uNewObject.Ptr().Reset()
+		p.releaseObject(uNewObject, isWrite)
+		p.chunkPool.ReleaseRawChunk(uintptr(uNewObject))
+	}
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return uintptr(uObject), loaded, nil
+}
+
+func (p *HKVTable[K]) TryGetObjectWithReadAcquire(objKey K) uintptr {
+	return p.tryGetObjectWithAcquire(objKey, false)
+}
+
+// TryGetObjectWithWriteAcquire is TryGetObjectWithReadAcquire with the object
+// write acquired, to be released by WriteRelease
+func (p *HKVTable[K]) TryGetObjectWithWriteAcquire(objKey K) uintptr {
+	return p.tryGetObjectWithAcquire(objKey, true)
+}
+
+func (p *HKVTable[K]) tryGetObjectWithAcquire(objKey K, isWrite bool) uintptr {
+	var (
+		uObject       HKVTableObjectUPtr[K] = 0
+		hash          uint64
+		shared        *hkvTableShared[K]
+		sharedRWMutex *sync.RWMutex
+	)
+
+	hash = p.hasher(objKey)
+
+	shared, sharedRWMutex = p.lockShared(hash, false)
+	uObject, _ = shared.get(objKey, hash)
+	sharedRWMutex.RUnlock()
+
+	if uObject != 0 {
+		p.acquireObject(uObject, isWrite)
+		if p.checkObject(uObject, objKey) == false {
+			p.releaseObject(uObject, isWrite)
+			uObject = 0
+		} else if p.isObjectExpired(uObject) {
+			p.releaseObject(uObject, isWrite)
+			p.deleteObject(objKey, true)
+			uObject = 0
+		} else {
+			p.evictionPolicy.OnAccess(uintptr(uObject))
+		}
+	}
+
+	return uintptr(uObject)
+}
+
+func (p *HKVTable[K]) DeleteObject(objKey K) {
+	p.deleteObject(objKey, false)
+}
+
+// deleteObject deletes the object of objKey, only if it is expired if
+// isExpiredOnly, and returns whether it is deleted
+func (p *HKVTable[K]) deleteObject(objKey K, isExpiredOnly bool) bool {
+	var (
+		uObject       HKVTableObjectUPtr[K]
+		hash          uint64
+		shared        *hkvTableShared[K]
+		sharedRWMutex *sync.RWMutex
+	)
+
+	hash = p.hasher(objKey)
+
+	for uObject == 0 {
+		shared, sharedRWMutex = p.lockShared(hash, false)
+		uObject, _ = shared.get(objKey, hash)
+		sharedRWMutex.RUnlock()
+
+		if uObject == 0 {
+			return false
+		}
+
+		uObject.Ptr().WriteAcquire()
+		if p.checkObject(uObject, objKey) == false {
+			uObject.Ptr().WriteRelease()
+			uObject = 0
+			continue
+		}
+	}
+
+	if isExpiredOnly && p.isObjectExpired(uObject) == false {
+		uObject.Ptr().WriteRelease()
+		return false
+	}
+
+	// assert uObject != 0
+
+	p.deleteWriteAcquiredObject(objKey, hash, uObject)
+
+	return true
+}
+
+// deleteWriteAcquiredObject deletes uObject, the object of objKey write acquired
+// by the caller, and releases it
+func (p *HKVTable[K]) deleteWriteAcquiredObject(objKey K, hash uint64, uObject HKVTableObjectUPtr[K]) {
+	var (
+		shared        *hkvTableShared[K]
+		sharedRWMutex *sync.RWMutex
+	)
+
+	for {
+		if p.beforeReleaseObjectFunc != nil {
+			p.beforeReleaseObjectFunc(uintptr(uObject))
+		} else {
+			uObject.Ptr().SetReleasable()
+		}
+
+		if uObject.Ptr().IsShouldRelease() {
+			shared, sharedRWMutex = p.lockShared(hash, true)
+			shared.delete(objKey, hash)
+			sharedRWMutex.Unlock()
+			p.evictionPolicy.OnDelete(uintptr(uObject))
+			p.freeObjectValue(uObject)
+			uObject.Ptr().Reset()
+			uObject.Ptr().WriteRelease()
+			p.chunkPool.ReleaseRawChunk(uintptr(uObject))
+			break
+		}
+	}
+}
+
+// Close stops the expire sweeper, unregisters the HKVTable from its
+// OffheapDriver and releases its memory, objects must not be used after Close
+func (p *HKVTable[K]) Close() error {
+	if p.offheapDriver != nil {
+		p.offheapDriver.deleteTable(p.name, p)
+	}
+
+	if p.sweeperStop != nil {
+		// blocks until the sweeper is out of SweepExpiredObjects
+		p.sweeperStop <- struct{}{}
+		p.sweeperStop = nil
+	}
+
+	p.reshardGate.beginScan()
+	for _, shareds := range p.sharedsChain() {
+		for sharedIndex := range shareds.shareds {
+			p.forEachSharedObject(shareds, sharedIndex, func(objKey K, uObject HKVTableObjectUPtr[K]) {
+				p.freeObjectValue(uObject)
+			})
+		}
+		shareds.reset()
+	}
+	p.reshardGate.endScan()
+
+	return p.chunkPool.Close()
+}
+
+// UpgradeObjectAcquire turns the read acquire of uObject got for objKey into a
+// write acquire. Writers may run in between, so it returns false and releases
+// uObject if the object of objKey is deleted meanwhile. The second result is
+// true if another writer acquired uObject meanwhile, the write acquire is kept
+// but the object must be read again before a read-modify-write.
+func (p *HKVTable[K]) UpgradeObjectAcquire(objKey K, uObject uintptr) (bool, bool) {
+	var (
+		v         = HKVTableObjectUPtr[K](uObject)
+		isWritten = v.Ptr().UpgradeAcquire() == false
+	)
+	if p.checkObject(v, objKey) == false {
+		v.Ptr().WriteRelease()
+		return false, isWritten
+	}
+	return true, isWritten
+}
+
+// DowngradeObjectAcquire turns the write acquire of uObject got for objKey into
+// a read acquire. Writers may run in between, so it returns false and releases
+// uObject if the object of objKey is deleted meanwhile.
+func (p *HKVTable[K]) DowngradeObjectAcquire(objKey K, uObject uintptr) bool {
+	var v = HKVTableObjectUPtr[K](uObject)
+	v.Ptr().DowngradeAcquire()
+	if p.checkObject(v, objKey) == false {
+		v.Ptr().ReadRelease()
+		return false
+	}
+	return true
+}
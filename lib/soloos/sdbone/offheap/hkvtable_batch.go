@@ -0,0 +1,287 @@
+package offheap
+
+import (
+	"sort"
+	"sync"
+)
+
+// HKVTableBatchResult is the result of a key of MultiMustGetWithReadAcquire
+type HKVTableBatchResult struct {
+	UObject uintptr
+	Loaded  bool
+	Err     error
+}
+
+type hkvTableBatchItem[K comparable] struct {
+	keyIndex    int
+	hash        uint64
+	sharedIndex int
+	uObject     HKVTableObjectUPtr[K]
+}
+
+type hkvTableBatchItemsByObject[K comparable] []hkvTableBatchItem[K]
+
+func (p hkvTableBatchItemsByObject[K]) Len() int           { return len(p) }
+func (p hkvTableBatchItemsByObject[K]) Less(i, j int) bool { return p[i].uObject < p[j].uObject }
+func (p hkvTableBatchItemsByObject[K]) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// prepareBatch returns the items of the distinct keys sorted by their shared in
+// shareds, and the index of the first key equal to each key, so that a batch
+// never acquires an object twice
+func (p *HKVTable[K]) prepareBatch(keys []K) (*hkvTableShareds[K], []hkvTableBatchItem[K], []int) {
+	var (
+		shareds      = p.loadShareds()
+		items        = make([]hkvTableBatchItem[K], 0, len(keys))
+		firstIndexes = make([]int, len(keys))
+		keyIndexes   = make(map[K]int, len(keys))
+		item         hkvTableBatchItem[K]
+	)
+	for i := range keys {
+		if firstIndex, isRepeated := keyIndexes[keys[i]]; isRepeated {
+			firstIndexes[i] = firstIndex
+			continue
+		}
+		keyIndexes[keys[i]] = i
+		firstIndexes[i] = i
+
+		item = hkvTableBatchItem[K]{keyIndex: i, hash: p.hasher(keys[i])}
+		item.sharedIndex = shareds.index(item.hash)
+		items = append(items, item)
+	}
+	return shareds, sortBatchItemsByShared(shareds, items), firstIndexes
+}
+
+// sortBatchItemsByShared returns items counting sorted by shared
+func sortBatchItemsByShared[K comparable](shareds *hkvTableShareds[K], items []hkvTableBatchItem[K]) []hkvTableBatchItem[K] {
+	var (
+		offsets     = make([]int, shareds.count+1)
+		sortedItems = make([]hkvTableBatchItem[K], len(items))
+	)
+
+	for i := range items {
+		offsets[items[i].sharedIndex+1]++
+	}
+	for i := 1; i < len(offsets); i++ {
+		offsets[i] += offsets[i-1]
+	}
+	for i := range items {
+		sortedItems[offsets[items[i].sharedIndex]] = items[i]
+		offsets[items[i].sharedIndex]++
+	}
+
+	return sortedItems
+}
+
+// sortBatchItemsByObject sorts items by object address, objects are acquired
+// in this order so that batches can not deadlock each other
+func sortBatchItemsByObject[K comparable](items []hkvTableBatchItem[K]) {
+	sort.Sort(hkvTableBatchItemsByObject[K](items))
+}
+
+// forEachBatchItem invokes fn on items sorted by their shared in shareds, with
+// the shared of each item locked, for write if isWrite. The lock of each shared
+// is taken once, but for the items of shareds migrated by Reshard which are
+// locked one by one.
+func (p *HKVTable[K]) forEachBatchItem(shareds *hkvTableShareds[K], items []hkvTableBatchItem[K],
+	isWrite bool, fn func(item *hkvTableBatchItem[K], shared *hkvTableShared[K])) {
+	var (
+		sharedIndex   int
+		sharedRWMutex *sync.RWMutex
+		shared        *hkvTableShared[K]
+		start, end    int
+	)
+
+	for start = 0; start < len(items); start = end {
+		sharedIndex = items[start].sharedIndex
+		for end = start + 1; end < len(items) && items[end].sharedIndex == sharedIndex; end++ {
+		}
+
+		lockRWMutex(&shareds.rwMutexs[sharedIndex], isWrite)
+		if shareds.migrated[sharedIndex] == false {
+			for i := start; i < end; i++ {
+				fn(&items[i], &shareds.shareds[sharedIndex])
+			}
+			unlockRWMutex(&shareds.rwMutexs[sharedIndex], isWrite)
+			continue
+		}
+		unlockRWMutex(&shareds.rwMutexs[sharedIndex], isWrite)
+
+		for i := start; i < end; i++ {
+			shared, sharedRWMutex = p.lockShared(items[i].hash, isWrite)
+			fn(&items[i], shared)
+			unlockRWMutex(sharedRWMutex, isWrite)
+		}
+	}
+}
+
+// lookupBatch sets the uObject of items, taking the lock of each shared once
+func (p *HKVTable[K]) lookupBatch(shareds *hkvTableShareds[K], keys []K, items []hkvTableBatchItem[K]) {
+	p.forEachBatchItem(shareds, items, false, func(item *hkvTableBatchItem[K], shared *hkvTableShared[K]) {
+		item.uObject, _ = shared.get(keys[item.keyIndex], item.hash)
+	})
+}
+
+// acquireBatch acquires the objects of items in address order, the uObject
+// of items whose object is deleted or expired is set to 0
+func (p *HKVTable[K]) acquireBatch(keys []K, items []hkvTableBatchItem[K], isWrite bool) {
+	sortBatchItemsByObject(items)
+	for i := range items {
+		if items[i].uObject == 0 {
+			continue
+		}
+
+		p.acquireObject(items[i].uObject, isWrite)
+		if p.checkObject(items[i].uObject, keys[items[i].keyIndex]) == false ||
+			p.isObjectExpired(items[i].uObject) {
+			p.releaseObject(items[i].uObject, isWrite)
+			items[i].uObject = 0
+			continue
+		}
+		p.evictionPolicy.OnAccess(uintptr(items[i].uObject))
+	}
+}
+
+// MultiGetWithReadAcquire is TryGetObjectWithReadAcquire of each key, the lock
+// of each shared is taken once. The result of a key is 0 if it has no object,
+// the object of a repeated key is only acquired for its first key, the others get 0.
+func (p *HKVTable[K]) MultiGetWithReadAcquire(keys []K) []uintptr {
+	var (
+		shareds, items, _ = p.prepareBatch(keys)
+		uObject           = make([]uintptr, len(keys))
+	)
+
+	p.lookupBatch(shareds, keys, items)
+	p.acquireBatch(keys, items, false)
+	for i := range items {
+		uObject[items[i].keyIndex] = uintptr(items[i].uObject)
+	}
+
+	return uObject
+}
+
+// MultiMustGetWithReadAcquire is MustGetObjectWithReadAcquire of each key, alloc
+// objects are inserted taking the lock of each shared once.
+// The object of a repeated key is only acquired for its first key, the others
+// get the Loaded and Err of the first key with an UObject 0.
+func (p *HKVTable[K]) MultiMustGetWithReadAcquire(keys []K) []HKVTableBatchResult {
+	var (
+		shareds, items, firstIndexes = p.prepareBatch(keys)
+		results                      = make([]HKVTableBatchResult, len(keys))
+		missingItems                 []hkvTableBatchItem[K]
+		retryItems                   []hkvTableBatchItem[K]
+		uObject                      HKVTableObjectUPtr[K]
+		err                          error
+	)
+
+	p.lookupBatch(shareds, keys, items)
+	p.acquireBatch(keys, items, false)
+	for i := range items {
+		if items[i].uObject != 0 {
+			results[items[i].keyIndex] = HKVTableBatchResult{UObject: uintptr(items[i].uObject), Loaded: true}
+			continue
+		}
+		missingItems = append(missingItems, items[i])
+	}
+
+	for i := range missingItems {
+		missingItems[i].uObject, err = p.allocObjectWithAcquire(keys[missingItems[i].keyIndex], false)
+		if err != nil {
+			results[missingItems[i].keyIndex].Err = err
+			continue
+		}
+		p.evictionPolicy.OnInsert(uintptr(missingItems[i].uObject))
+	}
+
+	missingItems = sortBatchItemsByShared(shareds, missingItems)
+	p.forEachBatchItem(shareds, missingItems, true, func(item *hkvTableBatchItem[K], shared *hkvTableShared[K]) {
+		if item.uObject == 0 {
+			return
+		}
+
+		uObject, _ = shared.get(keys[item.keyIndex], item.hash)
+		err = nil
+		if uObject == 0 {
+			err = shared.set(keys[item.keyIndex], item.hash, item.uObject)
+		}
+		if uObject != 0 || err != nil {
+			// set concurrently or expired, got one by one below
+			retryItems = append(retryItems, *item)
+			return
+		}
+		results[item.keyIndex] = HKVTableBatchResult{UObject: uintptr(item.uObject)}
+	})
+
+	for _, item := range retryItems {
+		p.evictionPolicy.OnDelete(uintptr(item.uObject))
+		item.uObject.Ptr().Reset()
+		item.uObject.Ptr().ReadRelease()
+		p.chunkPool.ReleaseRawChunk(uintptr(item.uObject))
+
+		results[item.keyIndex].UObject, results[item.keyIndex].Loaded, results[item.keyIndex].Err =
+			p.MustGetObjectWithReadAcquire(keys[item.keyIndex])
+	}
+
+	for i, firstIndex := range firstIndexes {
+		if firstIndex != i {
+			results[i] = HKVTableBatchResult{Loaded: results[firstIndex].Loaded, Err: results[firstIndex].Err}
+		}
+	}
+
+	return results
+}
+
+// MultiDelete is DeleteObject of each key, the lock of each shared is taken once.
+// The result of a key is true if its object is deleted by MultiDelete, it is
+// false for a repeated key but its first key.
+func (p *HKVTable[K]) MultiDelete(keys []K) []bool {
+	var (
+		shareds, items, _ = p.prepareBatch(keys)
+		deletedItems      []hkvTableBatchItem[K]
+		deleted           = make([]bool, len(keys))
+	)
+
+	p.lookupBatch(shareds, keys, items)
+
+	// expired objects are deleted too, unlike in acquireBatch
+	sortBatchItemsByObject(items)
+	for i := range items {
+		if items[i].uObject == 0 {
+			continue
+		}
+		items[i].uObject.Ptr().WriteAcquire()
+		if p.checkObject(items[i].uObject, keys[items[i].keyIndex]) == false {
+			items[i].uObject.Ptr().WriteRelease()
+			continue
+		}
+		deletedItems = append(deletedItems, items[i])
+	}
+
+	for _, item := range deletedItems {
+		for {
+			if p.beforeReleaseObjectFunc != nil {
+				p.beforeReleaseObjectFunc(uintptr(item.uObject))
+			} else {
+				item.uObject.Ptr().SetReleasable()
+			}
+			if item.uObject.Ptr().IsShouldRelease() {
+				break
+			}
+		}
+	}
+
+	deletedItems = sortBatchItemsByShared(shareds, deletedItems)
+	p.forEachBatchItem(shareds, deletedItems, true, func(item *hkvTableBatchItem[K], shared *hkvTableShared[K]) {
+		shared.delete(keys[item.keyIndex], item.hash)
+	})
+
+	for _, item := range deletedItems {
+		p.evictionPolicy.OnDelete(uintptr(item.uObject))
+		p.freeObjectValue(item.uObject)
+		item.uObject.Ptr().Reset()
+		item.uObject.Ptr().WriteRelease()
+		p.chunkPool.ReleaseRawChunk(uintptr(item.uObject))
+		deleted[item.keyIndex] = true
+	}
+
+	return deleted
+}
@@ -1,7 +1,5 @@
 package offheap
 
-import "sync"
-
 type HKVTableInvokePrepareNewObject func(v uintptr)
 type HKVTableInvokeBeforeReleaseObject func(v uintptr)
 
@@ -14,55 +12,61 @@ type HKVTableInvokeBeforeReleaseObject func(v uintptr)
 // user -> MustGetHKVTableObjectWithReadAcquire -> offheap.BlockPool.AllocBlock ->
 //      BlockPoolAssistant.ChunkPoolInvokePrepareNewChunk ->
 
-// inited by script
-
 type HKVTableCommon struct {
-	name         string
-	objectSize   int
-	objectsLimit int32
-	chunkPool    RawChunkPool
+	offheapDriver *OffheapDriver
+	name          string
+	objectSize    int
+	objectsLimit  int32
+	chunkPool     RawChunkPool
 	// chunkPool      ChunkPool
-	sharedCount    uint32
-	sharedRWMutexs []sync.RWMutex
+	evictionPolicy HKVTableEvictionPolicy
+	indexType      HKVTableIndexType
+	memoryBudget   *MemoryBudget
+	sweeperStop    chan struct{}
 
 	prepareNewObjectFunc    HKVTableInvokePrepareNewObject
 	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject
 }
 
-func (p *HKVTableCommon) GetSharedWithString(k string) int {
-	hash := uint32(2166136261)
-	const prime32 = uint32(16777619)
-	for i := 0; i < len(k); i++ {
-		hash *= prime32
-		hash ^= uint32(k[i])
-	}
-	return int(hash % p.sharedCount)
+// HKVTableStats is a snapshot of the statistics of a HKVTable
+type HKVTableStats struct {
+	Name       string
+	ObjectsNum int
+	PoolStats
 }
 
-func (p *HKVTableCommon) GetSharedWithBytes12(k [12]byte) int {
-	hash := uint32(2166136261)
-	const prime32 = uint32(16777619)
-	for i := 0; i < len(k); i++ {
-		hash *= prime32
-		hash ^= uint32(k[i])
+// mallocOffheapDriver mallocs the offheap indexes of shareds and the values of objects
+func (p *HKVTableCommon) mallocOffheapDriver() *OffheapDriver {
+	if p.offheapDriver != nil {
+		return p.offheapDriver
 	}
-	return int(hash % p.sharedCount)
+	return &DefaultOffheapDriver
 }
 
-func (p *HKVTableCommon) GetSharedWithBytes64(k [64]byte) int {
-	hash := uint32(2166136261)
-	const prime32 = uint32(16777619)
-	for i := 0; i < len(k); i++ {
-		hash *= prime32
-		hash ^= uint32(k[i])
+// initChunkPool inits the chunkPool of objects of chunkSize bytes, and registers it in
+// offheapDriver if the HKVTable is created by an OffheapDriver
+func (p *HKVTableCommon) initChunkPool(
+	prepareNewRawChunkFunc RawChunkPoolInvokePrepareNewRawChunk,
+	releaseRawChunkFunc RawChunkPoolInvokeReleaseRawChunk) error {
+	var (
+		poolID int64 = -1
+		err    error
+	)
+
+	if p.offheapDriver != nil {
+		poolID = p.offheapDriver.AllocTableID()
 	}
-	return int(hash % p.sharedCount)
-}
 
-func (p *HKVTableCommon) GetSharedWithInt32(k int32) int {
-	return int(k % (int32(p.sharedCount)))
-}
+	err = p.chunkPool.Init(poolID, p.objectSize, p.objectsLimit,
+		prepareNewRawChunkFunc, releaseRawChunkFunc,
+		PoolOptions{MemoryBudget: p.memoryBudget})
+	if err != nil {
+		return err
+	}
+
+	if p.offheapDriver != nil {
+		p.offheapDriver.SetRawChunkPool(&p.chunkPool)
+	}
 
-func (p *HKVTableCommon) GetSharedWithInt64(k int64) int {
-	return int(k % (int64(p.sharedCount)))
+	return nil
 }
@@ -0,0 +1,149 @@
+package offheap
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// HKVTableEvictionPolicy picks the objects a HKVTable releases when its
+// objectsLimit is reached. Its methods are invoked with uObject acquired,
+// except Victim, and must be safe for concurrent use.
+type HKVTableEvictionPolicy interface {
+	Init(objectsLimit int32)
+	// OnInsert is invoked before uObject is added to the HKVTable
+	OnInsert(uObject uintptr)
+	// OnAccess is invoked when uObject is got from the HKVTable
+	OnAccess(uObject uintptr)
+	// OnDelete is invoked when uObject is removed from the HKVTable
+	OnDelete(uObject uintptr)
+	// Victim returns the object to release among those isEvictable accepts, or 0.
+	// isEvictable must be invoked under the lock OnDelete takes, so that the
+	// object it is invoked on is not deleted meanwhile
+	Victim(isEvictable func(uObject uintptr) bool) uintptr
+}
+
+// HKVTableEvictionMeta is the head of every HKVTable object, it is kept by
+// the HKVTableEvictionPolicy of the HKVTable
+type HKVTableEvictionMeta struct {
+	Referenced uint32
+	Slot       int32
+}
+
+func GetHKVTableEvictionMeta(uObject uintptr) *HKVTableEvictionMeta {
+	return (*HKVTableEvictionMeta)(unsafe.Pointer(uObject))
+}
+
+// HKVTableOptions are the options of HKVTable
+type HKVTableOptions struct {
+	// EvictionPolicy is a new HKVTableCLOCK if nil
+	EvictionPolicy HKVTableEvictionPolicy
+	// ExpireSweepInterval is the interval of the background sweeper deleting
+	// expired objects, there is no sweeper if it is 0
+	ExpireSweepInterval time.Duration
+	// HashSeed seeds the default hasher of keys if it is not 0, by default
+	// the hasher is seeded by a random seed of the process
+	HashSeed uint64
+	// Index is the index of the objects of shareds, HKVTableIndexMap by default
+	Index HKVTableIndexType
+	// MemoryBudget, if not nil, limits the bytes of the objects of the HKVTable
+	// together with the other tables and pools of the budget, objectsLimit can be -1 then.
+	// Objects are evicted like when objectsLimit is reached, values are counted too.
+	MemoryBudget *MemoryBudget
+}
+
+func getHKVTableOptions(options []HKVTableOptions) HKVTableOptions {
+	var ret HKVTableOptions
+	if len(options) > 0 {
+		ret = options[0]
+	}
+	if ret.EvictionPolicy == nil {
+		ret.EvictionPolicy = new(HKVTableCLOCK)
+	}
+	return ret
+}
+
+// HKVTableCLOCK is the CLOCK eviction policy: objects sit in a ring, an access
+// sets the referenced bit of an object, and the hand sweeping the ring clears
+// referenced bits until it meets an object not referenced.
+// OnAccess takes no lock.
+type HKVTableCLOCK struct {
+	mutex     sync.Mutex
+	slots     []uintptr
+	freeSlots []int32
+	hand      int
+}
+
+var _ = HKVTableEvictionPolicy(&HKVTableCLOCK{})
+
+func (p *HKVTableCLOCK) Init(objectsLimit int32) {
+	if objectsLimit > 0 {
+		p.slots = make([]uintptr, 0, objectsLimit)
+	}
+}
+
+func (p *HKVTableCLOCK) OnInsert(uObject uintptr) {
+	var meta = GetHKVTableEvictionMeta(uObject)
+
+	atomic.StoreUint32(&meta.Referenced, 0)
+
+	p.mutex.Lock()
+	if last := len(p.freeSlots) - 1; last >= 0 {
+		meta.Slot = p.freeSlots[last]
+		p.freeSlots = p.freeSlots[:last]
+		p.slots[meta.Slot] = uObject
+	} else {
+		meta.Slot = int32(len(p.slots))
+		p.slots = append(p.slots, uObject)
+	}
+	p.mutex.Unlock()
+}
+
+func (p *HKVTableCLOCK) OnAccess(uObject uintptr) {
+	var meta = GetHKVTableEvictionMeta(uObject)
+	if atomic.LoadUint32(&meta.Referenced) == 0 {
+		atomic.StoreUint32(&meta.Referenced, 1)
+	}
+}
+
+func (p *HKVTableCLOCK) OnDelete(uObject uintptr) {
+	var meta = GetHKVTableEvictionMeta(uObject)
+
+	p.mutex.Lock()
+	p.slots[meta.Slot] = 0
+	p.freeSlots = append(p.freeSlots, meta.Slot)
+	p.mutex.Unlock()
+}
+
+func (p *HKVTableCLOCK) Victim(isEvictable func(uObject uintptr) bool) uintptr {
+	var (
+		uObject uintptr
+		meta    *HKVTableEvictionMeta
+	)
+
+	p.mutex.Lock()
+	// the first round may only clear referenced bits
+	for i := 0; i < len(p.slots)*2; i++ {
+		if p.hand >= len(p.slots) {
+			p.hand = 0
+		}
+		uObject = p.slots[p.hand]
+		p.hand++
+		if uObject == 0 {
+			continue
+		}
+
+		meta = GetHKVTableEvictionMeta(uObject)
+		if atomic.SwapUint32(&meta.Referenced, 0) == 1 {
+			continue
+		}
+		if isEvictable(uObject) {
+			p.mutex.Unlock()
+			return uObject
+		}
+	}
+	p.mutex.Unlock()
+
+	return 0
+}
@@ -0,0 +1,75 @@
+package offheap
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SetExpire sets the deadline of the object of objKey, a zero deadline
+// clears it. It returns false if the object does not exist.
+func (p *HKVTable[K]) SetExpire(objKey K, deadline time.Time) bool {
+	var (
+		uObject  HKVTableObjectUPtr[K]
+		expireAt int64
+	)
+
+	uObject = HKVTableObjectUPtr[K](p.TryGetObjectWithReadAcquire(objKey))
+	if uObject == 0 {
+		return false
+	}
+
+	if deadline.IsZero() == false {
+		expireAt = deadline.UnixNano()
+	}
+	atomic.StoreInt64(&uObject.Ptr().ExpireAt, expireAt)
+	uObject.Ptr().ReadRelease()
+
+	return true
+}
+
+// SweepExpiredObjects deletes the expired objects, through
+// beforeReleaseObjectFunc, and returns the number of deleted objects
+func (p *HKVTable[K]) SweepExpiredObjects() int {
+	var (
+		expiredKeys []K
+		deletedNum  int
+	)
+
+	p.reshardGate.beginScan()
+	for _, shareds := range p.sharedsChain() {
+		for sharedIndex := range shareds.shareds {
+			expiredKeys = expiredKeys[:0]
+			p.forEachSharedObject(shareds, sharedIndex, func(objKey K, uObject HKVTableObjectUPtr[K]) {
+				if p.isObjectExpired(uObject) {
+					expiredKeys = append(expiredKeys, objKey)
+				}
+			})
+
+			for _, objKey := range expiredKeys {
+				if p.deleteObject(objKey, true) {
+					deletedNum++
+				}
+			}
+		}
+	}
+	p.reshardGate.endScan()
+
+	return deletedNum
+}
+
+func (p *HKVTable[K]) startExpireSweeper(interval time.Duration) {
+	var stop = make(chan struct{})
+	p.sweeperStop = stop
+	go func() {
+		var ticker = time.NewTicker(interval)
+		for {
+			select {
+			case <-ticker.C:
+				p.SweepExpiredObjects()
+			case <-stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
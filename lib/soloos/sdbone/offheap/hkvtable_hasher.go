@@ -0,0 +1,233 @@
+package offheap
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math/bits"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+// HKVTableHasher hashes the keys of a HKVTable to pick their shared
+type HKVTableHasher[K comparable] func(k K) uint64
+
+const (
+	hashKey0 = 0xa0761d6478bd642f
+	hashKey1 = 0xe7037ed1a0b428db
+	hashKey2 = 0x8ebc6af09c88c6e3
+	hashKey3 = 0x589965cc75374cc3
+)
+
+// defaultHashSeed seeds the default hashers of the process, so that the
+// sharding of keys can not be predicted
+var defaultHashSeed = makeHashSeed()
+
+func makeHashSeed() uint64 {
+	var seed [8]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return uint64(time.Now().UnixNano())
+	}
+	return binary.LittleEndian.Uint64(seed[:])
+}
+
+func hashMix(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+func hashRead4(bytes []byte, i int) uint64 {
+	return uint64(binary.LittleEndian.Uint32(bytes[i:]))
+}
+
+func hashRead8(bytes []byte, i int) uint64 {
+	return binary.LittleEndian.Uint64(bytes[i:])
+}
+
+// HashBytes is the wyhash of bytes with seed
+func HashBytes(seed uint64, bytes []byte) uint64 {
+	var (
+		size = len(bytes)
+		a, b uint64
+	)
+
+	seed ^= hashKey0
+	switch {
+	case size == 0:
+		return hashMix(seed, hashKey1)
+	case size < 4:
+		a = uint64(bytes[0])<<16 | uint64(bytes[size>>1])<<8 | uint64(bytes[size-1])
+	case size <= 8:
+		a = hashRead4(bytes, 0)
+		b = hashRead4(bytes, size-4)
+		a, b = a<<32|b, b<<32|a
+	case size <= 16:
+		a = hashRead8(bytes, 0)
+		b = hashRead8(bytes, size-8)
+	default:
+		var i, left = 0, size
+		if left > 48 {
+			seed1, seed2 := seed, seed
+			for ; left > 48; left -= 48 {
+				seed = hashMix(hashRead8(bytes, i)^hashKey1, hashRead8(bytes, i+8)^seed)
+				seed1 = hashMix(hashRead8(bytes, i+16)^hashKey2, hashRead8(bytes, i+24)^seed1)
+				seed2 = hashMix(hashRead8(bytes, i+32)^hashKey3, hashRead8(bytes, i+40)^seed2)
+				i += 48
+			}
+			seed ^= seed1 ^ seed2
+		}
+		for ; left > 16; left -= 16 {
+			seed = hashMix(hashRead8(bytes, i)^hashKey1, hashRead8(bytes, i+8)^seed)
+			i += 16
+		}
+		a = hashRead8(bytes, size-16)
+		b = hashRead8(bytes, size-8)
+	}
+
+	return hashMix(hashKey1^uint64(size), hashMix(a^hashKey1, b^seed))
+}
+
+// HashString is the wyhash of str with seed
+func HashString(seed uint64, str string) uint64 {
+	return HashBytes(seed, unsafe.Slice(unsafe.StringData(str), len(str)))
+}
+
+// HashUint64 mixes v with seed, sequential values are spread over all bits
+func HashUint64(seed uint64, v uint64) uint64 {
+	return hashMix(hashKey1^8, hashMix(v^hashKey1, (v<<32|v>>32)^seed^hashKey0))
+}
+
+// DefaultHKVTableHasher returns the default hasher of K seeded by the
+// random seed of the process, or nil if K has none.
+func DefaultHKVTableHasher[K comparable]() HKVTableHasher[K] {
+	return DefaultHKVTableHasherWithSeed[K](defaultHashSeed)
+}
+
+// DefaultHKVTableHasherWithSeed returns the default hasher of K seeded by
+// seed, or nil if K has none.
+// Strings and byte arrays are hashed by HashBytes, integers by HashUint64.
+// Arrays and structs, such as composite keys, of integers, bools, strings and
+// byte arrays are hashed by hashing their fields, other keys have no default hasher.
+func DefaultHKVTableHasherWithSeed[K comparable](seed uint64) HKVTableHasher[K] {
+	var (
+		keyType = reflect.TypeOf((*K)(nil)).Elem()
+		keySize = keyType.Size()
+	)
+
+	switch keyType.Kind() {
+	case reflect.String:
+		return func(k K) uint64 {
+			return HashString(seed, *(*string)(unsafe.Pointer(&k)))
+		}
+
+	case reflect.Int8:
+		return func(k K) uint64 { return HashUint64(seed, uint64(*(*int8)(unsafe.Pointer(&k)))) }
+	case reflect.Int16:
+		return func(k K) uint64 { return HashUint64(seed, uint64(*(*int16)(unsafe.Pointer(&k)))) }
+	case reflect.Int32:
+		return func(k K) uint64 { return HashUint64(seed, uint64(*(*int32)(unsafe.Pointer(&k)))) }
+	case reflect.Int64:
+		return func(k K) uint64 { return HashUint64(seed, uint64(*(*int64)(unsafe.Pointer(&k)))) }
+	case reflect.Int:
+		return func(k K) uint64 { return HashUint64(seed, uint64(*(*int)(unsafe.Pointer(&k)))) }
+
+	case reflect.Uint8:
+		return func(k K) uint64 { return HashUint64(seed, uint64(*(*uint8)(unsafe.Pointer(&k)))) }
+	case reflect.Uint16:
+		return func(k K) uint64 { return HashUint64(seed, uint64(*(*uint16)(unsafe.Pointer(&k)))) }
+	case reflect.Uint32:
+		return func(k K) uint64 { return HashUint64(seed, uint64(*(*uint32)(unsafe.Pointer(&k)))) }
+	case reflect.Uint64:
+		return func(k K) uint64 { return HashUint64(seed, *(*uint64)(unsafe.Pointer(&k))) }
+	case reflect.Uint, reflect.Uintptr:
+		return func(k K) uint64 { return HashUint64(seed, uint64(*(*uint)(unsafe.Pointer(&k)))) }
+
+	case reflect.Array, reflect.Struct:
+		fields, isOK := appendHashFields(nil, keyType, 0)
+		if isOK == false {
+			return nil
+		}
+		if len(fields) == 1 && fields[0].isString == false && fields[0].size == keySize {
+			// no padding nor string, hashed as a whole
+			return func(k K) uint64 {
+				return HashBytes(seed, unsafe.Slice((*byte)(unsafe.Pointer(&k)), keySize))
+			}
+		}
+		return func(k K) uint64 {
+			return hashFields(seed, unsafe.Pointer(&k), fields)
+		}
+	}
+
+	return nil
+}
+
+// hashField is a part of a key hashed at once, a string or bytes
+type hashField struct {
+	offset   uintptr
+	size     uintptr
+	isString bool
+}
+
+// appendHashFields appends the fields of a value of type t at offset to fields,
+// contiguous bytes are merged into one field and padding is skipped.
+// It returns false if t has no default hasher.
+func appendHashFields(fields []hashField, t reflect.Type, offset uintptr) ([]hashField, bool) {
+	var isOK bool
+
+	switch t.Kind() {
+	case reflect.String:
+		return append(fields, hashField{offset: offset, size: t.Size(), isString: true}), true
+
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if len(fields) > 0 {
+			last := &fields[len(fields)-1]
+			if last.isString == false && last.offset+last.size == offset {
+				last.size += t.Size()
+				return fields, true
+			}
+		}
+		return append(fields, hashField{offset: offset, size: t.Size()}), true
+
+	case reflect.Array:
+		for i := 0; i < t.Len(); i++ {
+			fields, isOK = appendHashFields(fields, t.Elem(), offset+uintptr(i)*t.Elem().Size())
+			if isOK == false {
+				return nil, false
+			}
+		}
+		return fields, true
+
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			// blank fields are ignored by ==
+			if t.Field(i).Name == "_" {
+				continue
+			}
+			fields, isOK = appendHashFields(fields, t.Field(i).Type, offset+t.Field(i).Offset)
+			if isOK == false {
+				return nil, false
+			}
+		}
+		return fields, true
+	}
+
+	return nil, false
+}
+
+func hashFields(seed uint64, ptr unsafe.Pointer, fields []hashField) uint64 {
+	var hash, fieldHash uint64
+
+	hash = seed
+	for i := range fields {
+		if fields[i].isString {
+			fieldHash = HashString(seed, *(*string)(unsafe.Add(ptr, fields[i].offset)))
+		} else {
+			fieldHash = HashBytes(seed, unsafe.Slice((*byte)(unsafe.Add(ptr, fields[i].offset)), fields[i].size))
+		}
+		hash = hashMix(hash^fieldHash, hashKey2)
+	}
+
+	return hash
+}
@@ -0,0 +1,271 @@
+package offheap
+
+import "unsafe"
+
+// HKVTableIndexType is the kind of index mapping the keys of a HKVTable shared to its objects
+type HKVTableIndexType int
+
+const (
+	// HKVTableIndexMap indexes objects by go maps
+	HKVTableIndexMap HKVTableIndexType = iota
+	// HKVTableIndexOffheap indexes objects by open addressing hash tables
+	// malloced in offheap memory, which GC does not scan. Keys are only kept
+	// in objects then, so they must hold no pointer, strings are not supported.
+	HKVTableIndexOffheap
+)
+
+const (
+	hkvTableIndexSlotSize     = int(unsafe.Sizeof(hkvTableIndexSlot{}))
+	hkvTableIndexMinSlotsNum  = 16
+	hkvTableIndexFibonacciMul = 0x9e3779b97f4a7c15
+)
+
+// hkvTableIndexSlot is empty if uObject is 0
+type hkvTableIndexSlot struct {
+	hash    uint64
+	uObject uintptr
+}
+
+// hkvTableOffheapIndex is a linear probing hash table of objects, keys are
+// not stored but compared with the ID of objects.
+// It must be protected by the sharedRWMutex of its shared.
+type hkvTableOffheapIndex[K comparable] struct {
+	offheapDriver *OffheapDriver
+	slots         uintptr
+	slotsMask     uint64
+	slotsShift    uint
+	len           int
+}
+
+func (p *hkvTableOffheapIndex[K]) init(offheapDriver *OffheapDriver) error {
+	p.offheapDriver = offheapDriver
+	return p.resize(hkvTableIndexMinSlotsNum)
+}
+
+func (p *hkvTableOffheapIndex[K]) slot(i uint64) *hkvTableIndexSlot {
+	return (*hkvTableIndexSlot)(unsafe.Pointer(p.slots + uintptr(i)*uintptr(hkvTableIndexSlotSize)))
+}
+
+// home is the first slot probed for hash, fibonacci hashing spreads
+// hashers only filling the low bits
+func (p *hkvTableOffheapIndex[K]) home(hash uint64) uint64 {
+	return (hash * hkvTableIndexFibonacciMul) >> p.slotsShift
+}
+
+func (p *hkvTableOffheapIndex[K]) get(objKey K, hash uint64) (HKVTableObjectUPtr[K], bool) {
+	var (
+		i    = p.home(hash)
+		slot *hkvTableIndexSlot
+	)
+
+	for {
+		slot = p.slot(i)
+		if slot.uObject == 0 {
+			return 0, false
+		}
+		if slot.hash == hash && HKVTableObjectUPtr[K](slot.uObject).Ptr().ID == objKey {
+			return HKVTableObjectUPtr[K](slot.uObject), true
+		}
+		i = (i + 1) & p.slotsMask
+	}
+}
+
+func (p *hkvTableOffheapIndex[K]) set(objKey K, hash uint64, uObject HKVTableObjectUPtr[K]) error {
+	var (
+		i    uint64
+		slot *hkvTableIndexSlot
+		err  error
+	)
+
+	// grows at load factor 3/4
+	if (p.len+1)*4 > int(p.slotsMask+1)*3 {
+		err = p.resize(int(p.slotsMask+1) * 2)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i = p.home(hash); ; i = (i + 1) & p.slotsMask {
+		slot = p.slot(i)
+		if slot.uObject == 0 {
+			p.len++
+			break
+		}
+		if slot.hash == hash && HKVTableObjectUPtr[K](slot.uObject).Ptr().ID == objKey {
+			break
+		}
+	}
+	slot.hash = hash
+	slot.uObject = uintptr(uObject)
+
+	return nil
+}
+
+// delete removes objKey by shifting back the following slots, so that no
+// tombstone is needed
+func (p *hkvTableOffheapIndex[K]) delete(objKey K, hash uint64) {
+	var (
+		i, j, home uint64
+		slot       *hkvTableIndexSlot
+	)
+
+	for i = p.home(hash); ; i = (i + 1) & p.slotsMask {
+		slot = p.slot(i)
+		if slot.uObject == 0 {
+			return
+		}
+		if slot.hash == hash && HKVTableObjectUPtr[K](slot.uObject).Ptr().ID == objKey {
+			break
+		}
+	}
+
+	for j = (i + 1) & p.slotsMask; p.slot(j).uObject != 0; j = (j + 1) & p.slotsMask {
+		home = p.home(p.slot(j).hash)
+		// slot j may fill slot i if i is cyclically in [home, j)
+		if (j > i && (home <= i || home > j)) || (j < i && home <= i && home > j) {
+			*p.slot(i) = *p.slot(j)
+			i = j
+		}
+	}
+	*p.slot(i) = hkvTableIndexSlot{}
+	p.len--
+}
+
+func (p *hkvTableOffheapIndex[K]) forEach(fn func(objKey K, uObject HKVTableObjectUPtr[K])) {
+	var i uint64
+	if p.slots == 0 {
+		return
+	}
+	for i = 0; i <= p.slotsMask; i++ {
+		if uObject := HKVTableObjectUPtr[K](p.slot(i).uObject); uObject != 0 {
+			fn(uObject.Ptr().ID, uObject)
+		}
+	}
+}
+
+func (p *hkvTableOffheapIndex[K]) resize(slotsNum int) error {
+	var (
+		oldSlots     = p.slots
+		oldSlotsMask = p.slotsMask
+		slot         *hkvTableIndexSlot
+		size         = slotsNum * hkvTableIndexSlotSize
+		slots        uintptr
+		i            uint64
+		err          error
+	)
+
+	slots, err = p.offheapDriver.Malloc(size)
+	if err != nil {
+		return err
+	}
+	clearBytes := (*[1 << 40]byte)(unsafe.Pointer(slots))[:size:size]
+	for k := range clearBytes {
+		clearBytes[k] = 0
+	}
+
+	p.slots = slots
+	p.slotsMask = uint64(slotsNum - 1)
+	p.slotsShift = uint(64)
+	for n := slotsNum; n > 1; n >>= 1 {
+		p.slotsShift--
+	}
+	p.len = 0
+
+	if oldSlots == 0 {
+		return nil
+	}
+
+	for i = 0; i <= oldSlotsMask; i++ {
+		slot = (*hkvTableIndexSlot)(unsafe.Pointer(oldSlots + uintptr(i)*uintptr(hkvTableIndexSlotSize)))
+		if slot.uObject == 0 {
+			continue
+		}
+		for k := p.home(slot.hash); ; k = (k + 1) & p.slotsMask {
+			if p.slot(k).uObject == 0 {
+				*p.slot(k) = *slot
+				p.len++
+				break
+			}
+		}
+	}
+	p.offheapDriver.Free(oldSlots)
+
+	return nil
+}
+
+func (p *hkvTableOffheapIndex[K]) free() {
+	if p.slots != 0 {
+		p.offheapDriver.Free(p.slots)
+		p.slots = 0
+	}
+	p.slotsMask = 0
+	p.len = 0
+}
+
+// hkvTableShared is the index of a shared of HKVTable, a go map or a
+// hkvTableOffheapIndex. hash is the hash of objKey by the hasher of the HKVTable.
+type hkvTableShared[K comparable] struct {
+	objects map[K]HKVTableObjectUPtr[K]
+	index   *hkvTableOffheapIndex[K]
+}
+
+func (p *hkvTableShared[K]) init(indexType HKVTableIndexType, offheapDriver *OffheapDriver) error {
+	if indexType == HKVTableIndexOffheap {
+		p.objects = nil
+		p.index = new(hkvTableOffheapIndex[K])
+		return p.index.init(offheapDriver)
+	}
+
+	p.objects = make(map[K]HKVTableObjectUPtr[K])
+	p.index = nil
+	return nil
+}
+
+func (p *hkvTableShared[K]) get(objKey K, hash uint64) (HKVTableObjectUPtr[K], bool) {
+	if p.index != nil {
+		return p.index.get(objKey, hash)
+	}
+	uObject, exists := p.objects[objKey]
+	return uObject, exists
+}
+
+func (p *hkvTableShared[K]) set(objKey K, hash uint64, uObject HKVTableObjectUPtr[K]) error {
+	if p.index != nil {
+		return p.index.set(objKey, hash, uObject)
+	}
+	p.objects[objKey] = uObject
+	return nil
+}
+
+func (p *hkvTableShared[K]) delete(objKey K, hash uint64) {
+	if p.index != nil {
+		p.index.delete(objKey, hash)
+		return
+	}
+	delete(p.objects, objKey)
+}
+
+func (p *hkvTableShared[K]) len() int {
+	if p.index != nil {
+		return p.index.len
+	}
+	return len(p.objects)
+}
+
+func (p *hkvTableShared[K]) forEach(fn func(objKey K, uObject HKVTableObjectUPtr[K])) {
+	if p.index != nil {
+		p.index.forEach(fn)
+		return
+	}
+	for objKey, uObject := range p.objects {
+		fn(objKey, uObject)
+	}
+}
+
+// reset empties the shared and frees its offheap memory, it can not be used until init
+func (p *hkvTableShared[K]) reset() {
+	if p.index != nil {
+		p.index.free()
+	}
+	p.objects = make(map[K]HKVTableObjectUPtr[K])
+}
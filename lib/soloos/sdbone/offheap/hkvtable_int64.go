@@ -1,315 +0,0 @@
-package offheap
-
-import (
-	"sync"
-	"unsafe"
-)
-
-// inited by script
-
-type HKVTableObjectUPtrWithInt64 uintptr
-
-func (u HKVTableObjectUPtrWithInt64) Ptr() *HKVTableObjectWithInt64 {
-	return (*HKVTableObjectWithInt64)(unsafe.Pointer(u))
-}
-
-type HKVTableObjectWithInt64 struct {
-	ID int64
-	HSharedPointer
-}
-
-// Heavy Key-Value table
-type HKVTableWithInt64 struct {
-	HKVTableCommon
-	shareds []map[int64]HKVTableObjectUPtrWithInt64
-}
-
-func (p *OffheapDriver) CreateHKVTableWithInt64(name string,
-	objectSize int, objectsLimit int32, sharedCount uint32,
-	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
-	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
-) (*HKVTableWithInt64, error) {
-	var (
-		kvTable = new(HKVTableWithInt64)
-		err     error
-	)
-	err = kvTable.Init(name, objectSize, objectsLimit, sharedCount,
-		prepareNewObjectFunc,
-		beforeReleaseObjectFunc,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	return kvTable, err
-}
-
-func (p *HKVTableWithInt64) Init(name string,
-	objectSize int, objectsLimit int32, sharedCount uint32,
-	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
-	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
-) error {
-	var err error
-
-	p.name = name
-	p.objectSize = objectSize
-	p.objectsLimit = objectsLimit
-
-	p.sharedCount = sharedCount
-	p.sharedRWMutexs = make([]sync.RWMutex, p.sharedCount)
-
-	err = p.prepareShareds(p.objectSize, p.objectsLimit)
-	if err != nil {
-		return err
-	}
-
-	p.prepareNewObjectFunc = prepareNewObjectFunc
-	p.beforeReleaseObjectFunc = beforeReleaseObjectFunc
-
-	return nil
-}
-
-func (p *HKVTableWithInt64) Name() string {
-	return p.name
-}
-
-func (p *HKVTableWithInt64) prepareShareds(objectSize int, objectsLimit int32) error {
-	var (
-		sharedIndex uint32
-		err         error
-	)
-	p.shareds = make([]map[int64]HKVTableObjectUPtrWithInt64, p.sharedCount)
-	for sharedIndex = 0; sharedIndex < p.sharedCount; sharedIndex++ {
-		p.shareds[sharedIndex] = make(map[int64]HKVTableObjectUPtrWithInt64)
-	}
-
-	err = p.chunkPool.Init(-1, objectSize, objectsLimit,
-		p.chunkPoolInvokePrepareNewChunk,
-		p.chunkPoolInvokeReleaseChunkInt64)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (p *HKVTableWithInt64) chunkPoolInvokePrepareNewChunk(uChunk uintptr) {
-	if p.prepareNewObjectFunc != nil {
-		p.prepareNewObjectFunc(uChunk)
-	}
-}
-
-func (p *HKVTableWithInt64) chunkPoolInvokeReleaseChunkInt64() {
-	var (
-		sharedIndex     uint32
-		shared          *map[int64]HKVTableObjectUPtrWithInt64
-		sharedRWMutex   *sync.RWMutex
-		objKey          int64
-		uObject         HKVTableObjectUPtrWithInt64
-		uReleaseTargetK int64
-		uReleaseTarget  HKVTableObjectUPtrWithInt64
-	)
-
-	for sharedIndex = 0; sharedIndex < p.sharedCount; sharedIndex++ {
-		shared = &p.shareds[sharedIndex]
-		sharedRWMutex = &p.sharedRWMutexs[sharedIndex]
-
-		sharedRWMutex.RLock()
-		for objKey, uObject = range *shared {
-			if uObject.Ptr().GetAccessor() == 0 {
-				uReleaseTargetK = objKey
-				uReleaseTarget = uObject
-				break
-			}
-		}
-		sharedRWMutex.RUnlock()
-		if uReleaseTarget != 0 {
-			goto FIND_TARGET_DONE
-		}
-	}
-
-	if uReleaseTarget != 0 {
-		goto FIND_TARGET_DONE
-	}
-
-	for sharedIndex = 0; sharedIndex < p.sharedCount; sharedIndex++ {
-		shared = &p.shareds[sharedIndex]
-		sharedRWMutex = &p.sharedRWMutexs[sharedIndex]
-
-		sharedRWMutex.RLock()
-		for objKey, uObject = range *shared {
-			uReleaseTargetK = objKey
-			uReleaseTarget = uObject
-			break
-		}
-		sharedRWMutex.RUnlock()
-		if uReleaseTarget != 0 {
-			goto FIND_TARGET_DONE
-		}
-	}
-
-FIND_TARGET_DONE:
-	if uReleaseTarget != 0 {
-		p.DeleteObject(uReleaseTargetK)
-	}
-}
-
-func (p *HKVTableWithInt64) allocObjectWithInt64WithReadAcquire(objKey int64) HKVTableObjectUPtrWithInt64 {
-	var uObject = HKVTableObjectUPtrWithInt64(p.chunkPool.AllocRawChunk())
-	uObject.Ptr().ReadAcquire()
-	uObject.Ptr().ID = objKey
-	uObject.Ptr().CompleteInit()
-	return uObject
-}
-
-func (p *HKVTableWithInt64) checkObject(v HKVTableObjectUPtrWithInt64, objKey int64) bool {
-	return v.Ptr().ID == objKey && v.Ptr().IsInited()
-}
-
-func (p *HKVTableWithInt64) MustGetObjectWithReadAcquire(objKey int64) (uintptr, bool) {
-	var (
-		uObject       HKVTableObjectUPtrWithInt64 = 0
-		shared        *map[int64]HKVTableObjectUPtrWithInt64
-		sharedRWMutex *sync.RWMutex
-		loaded        bool = false
-	)
-
-	{
-		sharedIndex := p.GetSharedWithInt64(objKey)
-		shared = &p.shareds[sharedIndex]
-		sharedRWMutex = &p.sharedRWMutexs[sharedIndex]
-	}
-
-	sharedRWMutex.RLock()
-	uObject, loaded = (*shared)[objKey]
-	sharedRWMutex.RUnlock()
-
-	if uObject != 0 {
-		uObject.Ptr().ReadAcquire()
-		if p.checkObject(uObject, objKey) == false {
-			uObject.Ptr().ReadRelease()
-			uObject = 0
-			loaded = false
-		} else {
-			loaded = true
-		}
-	}
-
-	if uObject != 0 {
-		return uintptr(uObject), loaded
-	}
-
-	var (
-		uNewObject        HKVTableObjectUPtrWithInt64 = p.allocObjectWithInt64WithReadAcquire(objKey)
-		isNewObjectSetted bool                        = false
-	)
-
-	for isNewObjectSetted == false && loaded == false {
-		sharedRWMutex.Lock()
-		uObject, loaded = (*shared)[objKey]
-		if uObject == 0 {
-			uObject = uNewObject
-			(*shared)[objKey] = uObject
-			isNewObjectSetted = true
-		}
-		sharedRWMutex.Unlock()
-
-		if isNewObjectSetted == false {
-			uObject.Ptr().ReadAcquire()
-			if p.checkObject(uObject, objKey) == false {
-				uObject.Ptr().ReadRelease()
-				uObject = 0
-				loaded = false
-			} else {
-				loaded = true
-			}
-		}
-	}
-
-	if isNewObjectSetted == false {
-		uNewObject.Ptr().Reset()
-		uNewObject.Ptr().ReadRelease()
-		p.chunkPool.ReleaseRawChunk(uintptr(uNewObject))
-	}
-
-	return uintptr(uObject), loaded
-}
-
-func (p *HKVTableWithInt64) TryGetObjectWithReadAcquire(objKey int64) uintptr {
-	var (
-		uObject       HKVTableObjectUPtrWithInt64 = 0
-		shared        *map[int64]HKVTableObjectUPtrWithInt64
-		sharedRWMutex *sync.RWMutex
-	)
-
-	{
-		sharedIndex := p.GetSharedWithInt64(objKey)
-		shared = &p.shareds[sharedIndex]
-		sharedRWMutex = &p.sharedRWMutexs[sharedIndex]
-	}
-
-	sharedRWMutex.RLock()
-	uObject, _ = (*shared)[objKey]
-	sharedRWMutex.RUnlock()
-
-	if uObject != 0 {
-		uObject.Ptr().ReadAcquire()
-		if p.checkObject(uObject, objKey) == false {
-			uObject.Ptr().ReadRelease()
-			uObject = 0
-		}
-	}
-
-	return uintptr(uObject)
-}
-
-func (p *HKVTableWithInt64) DeleteObject(objKey int64) {
-	var (
-		uObject       HKVTableObjectUPtrWithInt64
-		shared        *map[int64]HKVTableObjectUPtrWithInt64
-		sharedRWMutex *sync.RWMutex
-	)
-
-	{
-		sharedIndex := p.GetSharedWithInt64(objKey)
-		shared = &p.shareds[sharedIndex]
-		sharedRWMutex = &p.sharedRWMutexs[sharedIndex]
-	}
-
-	for uObject == 0 {
-		sharedRWMutex.RLock()
-		uObject, _ = (*shared)[objKey]
-		sharedRWMutex.RUnlock()
-
-		if uObject == 0 {
-			return
-		}
-
-		uObject.Ptr().WriteAcquire()
-		if p.checkObject(uObject, objKey) == false {
-			uObject.Ptr().WriteRelease()
-			uObject = 0
-			continue
-		}
-	}
-
-	// assert uObject != 0
-
-	for {
-		if p.beforeReleaseObjectFunc != nil {
-			p.beforeReleaseObjectFunc(uintptr(uObject))
-		} else {
-			uObject.Ptr().SetReleasable()
-		}
-
-		if uObject.Ptr().IsShouldRelease() {
-			sharedRWMutex.Lock()
-			delete(*shared, objKey)
-			sharedRWMutex.Unlock()
-			uObject.Ptr().Reset()
-			uObject.Ptr().WriteRelease()
-			p.chunkPool.ReleaseRawChunk(uintptr(uObject))
-			break
-		}
-	}
-}
@@ -0,0 +1,136 @@
+package offheap
+
+// the HKVTables of the key types used before HKVTable was generic
+
+type HKVTableObjectUPtrWithString = HKVTableObjectUPtr[string]
+type HKVTableObjectWithString = HKVTableObject[string]
+type HKVTableWithString = HKVTable[string]
+
+type HKVTableObjectUPtrWithInt32 = HKVTableObjectUPtr[int32]
+type HKVTableObjectWithInt32 = HKVTableObject[int32]
+type HKVTableWithInt32 = HKVTable[int32]
+
+type HKVTableObjectUPtrWithInt64 = HKVTableObjectUPtr[int64]
+type HKVTableObjectWithInt64 = HKVTableObject[int64]
+type HKVTableWithInt64 = HKVTable[int64]
+
+type HKVTableObjectUPtrWithUint64 = HKVTableObjectUPtr[uint64]
+type HKVTableObjectWithUint64 = HKVTableObject[uint64]
+type HKVTableWithUint64 = HKVTable[uint64]
+
+type HKVTableObjectUPtrWithBytes12 = HKVTableObjectUPtr[[12]byte]
+type HKVTableObjectWithBytes12 = HKVTableObject[[12]byte]
+type HKVTableWithBytes12 = HKVTable[[12]byte]
+
+type HKVTableObjectUPtrWithBytes16 = HKVTableObjectUPtr[[16]byte]
+type HKVTableObjectWithBytes16 = HKVTableObject[[16]byte]
+type HKVTableWithBytes16 = HKVTable[[16]byte]
+
+type HKVTableObjectUPtrWithBytes32 = HKVTableObjectUPtr[[32]byte]
+type HKVTableObjectWithBytes32 = HKVTableObject[[32]byte]
+type HKVTableWithBytes32 = HKVTable[[32]byte]
+
+type HKVTableObjectUPtrWithBytes64 = HKVTableObjectUPtr[[64]byte]
+type HKVTableObjectWithBytes64 = HKVTableObject[[64]byte]
+type HKVTableWithBytes64 = HKVTable[[64]byte]
+
+// HKVTableKeyPair is a composite key, such as (tenantID, objectID), it is
+// sharded by the default hasher as long as K0 and K1 have one
+type HKVTableKeyPair[K0, K1 comparable] struct {
+	First  K0
+	Second K1
+}
+
+type HKVTableObjectUPtrWithInt64Bytes16 = HKVTableObjectUPtr[HKVTableKeyPair[int64, [16]byte]]
+type HKVTableObjectWithInt64Bytes16 = HKVTableObject[HKVTableKeyPair[int64, [16]byte]]
+type HKVTableWithInt64Bytes16 = HKVTable[HKVTableKeyPair[int64, [16]byte]]
+
+func (p *OffheapDriver) CreateHKVTableWithString(name string,
+	objectSize int, objectsLimit int32, sharedCount uint32,
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+	options ...HKVTableOptions,
+) (*HKVTableWithString, error) {
+	return CreateHKVTable[string](p, name, objectSize, objectsLimit, sharedCount,
+		nil, prepareNewObjectFunc, beforeReleaseObjectFunc, options...)
+}
+
+func (p *OffheapDriver) CreateHKVTableWithInt32(name string,
+	objectSize int, objectsLimit int32, sharedCount uint32,
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+	options ...HKVTableOptions,
+) (*HKVTableWithInt32, error) {
+	return CreateHKVTable[int32](p, name, objectSize, objectsLimit, sharedCount,
+		nil, prepareNewObjectFunc, beforeReleaseObjectFunc, options...)
+}
+
+func (p *OffheapDriver) CreateHKVTableWithInt64(name string,
+	objectSize int, objectsLimit int32, sharedCount uint32,
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+	options ...HKVTableOptions,
+) (*HKVTableWithInt64, error) {
+	return CreateHKVTable[int64](p, name, objectSize, objectsLimit, sharedCount,
+		nil, prepareNewObjectFunc, beforeReleaseObjectFunc, options...)
+}
+
+func (p *OffheapDriver) CreateHKVTableWithUint64(name string,
+	objectSize int, objectsLimit int32, sharedCount uint32,
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+	options ...HKVTableOptions,
+) (*HKVTableWithUint64, error) {
+	return CreateHKVTable[uint64](p, name, objectSize, objectsLimit, sharedCount,
+		nil, prepareNewObjectFunc, beforeReleaseObjectFunc, options...)
+}
+
+func (p *OffheapDriver) CreateHKVTableWithBytes12(name string,
+	objectSize int, objectsLimit int32, sharedCount uint32,
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+	options ...HKVTableOptions,
+) (*HKVTableWithBytes12, error) {
+	return CreateHKVTable[[12]byte](p, name, objectSize, objectsLimit, sharedCount,
+		nil, prepareNewObjectFunc, beforeReleaseObjectFunc, options...)
+}
+
+func (p *OffheapDriver) CreateHKVTableWithBytes16(name string,
+	objectSize int, objectsLimit int32, sharedCount uint32,
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+	options ...HKVTableOptions,
+) (*HKVTableWithBytes16, error) {
+	return CreateHKVTable[[16]byte](p, name, objectSize, objectsLimit, sharedCount,
+		nil, prepareNewObjectFunc, beforeReleaseObjectFunc, options...)
+}
+
+func (p *OffheapDriver) CreateHKVTableWithBytes32(name string,
+	objectSize int, objectsLimit int32, sharedCount uint32,
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+	options ...HKVTableOptions,
+) (*HKVTableWithBytes32, error) {
+	return CreateHKVTable[[32]byte](p, name, objectSize, objectsLimit, sharedCount,
+		nil, prepareNewObjectFunc, beforeReleaseObjectFunc, options...)
+}
+
+func (p *OffheapDriver) CreateHKVTableWithBytes64(name string,
+	objectSize int, objectsLimit int32, sharedCount uint32,
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+	options ...HKVTableOptions,
+) (*HKVTableWithBytes64, error) {
+	return CreateHKVTable[[64]byte](p, name, objectSize, objectsLimit, sharedCount,
+		nil, prepareNewObjectFunc, beforeReleaseObjectFunc, options...)
+}
+
+func (p *OffheapDriver) CreateHKVTableWithInt64Bytes16(name string,
+	objectSize int, objectsLimit int32, sharedCount uint32,
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+	options ...HKVTableOptions,
+) (*HKVTableWithInt64Bytes16, error) {
+	return CreateHKVTable[HKVTableKeyPair[int64, [16]byte]](p, name, objectSize, objectsLimit, sharedCount,
+		nil, prepareNewObjectFunc, beforeReleaseObjectFunc, options...)
+}
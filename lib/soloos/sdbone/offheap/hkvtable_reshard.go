@@ -0,0 +1,275 @@
+package offheap
+
+import "sync"
+
+// hkvTableShareds is the array of shareds of a HKVTable. Reshard migrates its
+// shareds one by one to next, a migrated shared is empty and the objects of its
+// keys are in next.
+type hkvTableShareds[K comparable] struct {
+	count    uint32
+	rwMutexs []sync.RWMutex
+	shareds  []hkvTableShared[K]
+	// migrated[i] is guarded by rwMutexs[i]
+	migrated []bool
+	// next is set before any shared is migrated, under the reshardGate
+	next *hkvTableShareds[K]
+}
+
+func (p *hkvTableShareds[K]) index(hash uint64) int {
+	return int(hash % uint64(p.count))
+}
+
+// reset empties every shared not migrated and frees their offheap memory
+func (p *hkvTableShareds[K]) reset() {
+	for i := range p.shareds {
+		p.rwMutexs[i].Lock()
+		if p.migrated[i] == false {
+			p.shareds[i].reset()
+		}
+		p.rwMutexs[i].Unlock()
+	}
+}
+
+// hkvTableReshardGate lets Reshard migrate a shared only while no scan walks
+// the shareds. Unlike a RWMutex, a waiting Reshard does not block new scans, so
+// that scans can be nested.
+type hkvTableReshardGate struct {
+	mutex       sync.Mutex
+	cond        sync.Cond
+	scansNum    int
+	isMigrating bool
+}
+
+func (p *hkvTableReshardGate) init() {
+	p.cond.L = &p.mutex
+}
+
+func (p *hkvTableReshardGate) beginScan() {
+	p.mutex.Lock()
+	for p.isMigrating {
+		p.cond.Wait()
+	}
+	p.scansNum++
+	p.mutex.Unlock()
+}
+
+func (p *hkvTableReshardGate) endScan() {
+	p.mutex.Lock()
+	p.scansNum--
+	if p.scansNum == 0 {
+		p.cond.Broadcast()
+	}
+	p.mutex.Unlock()
+}
+
+func (p *hkvTableReshardGate) beginMigrate() {
+	p.mutex.Lock()
+	for p.scansNum > 0 {
+		p.cond.Wait()
+	}
+	p.isMigrating = true
+	p.mutex.Unlock()
+}
+
+func (p *hkvTableReshardGate) endMigrate() {
+	p.mutex.Lock()
+	p.isMigrating = false
+	p.cond.Broadcast()
+	p.mutex.Unlock()
+}
+
+func lockRWMutex(rwMutex *sync.RWMutex, isWrite bool) {
+	if isWrite {
+		rwMutex.Lock()
+	} else {
+		rwMutex.RLock()
+	}
+}
+
+func unlockRWMutex(rwMutex *sync.RWMutex, isWrite bool) {
+	if isWrite {
+		rwMutex.Unlock()
+	} else {
+		rwMutex.RUnlock()
+	}
+}
+
+func (p *HKVTable[K]) newShareds(sharedCount uint32) (*hkvTableShareds[K], error) {
+	var (
+		shareds = &hkvTableShareds[K]{
+			count:    sharedCount,
+			rwMutexs: make([]sync.RWMutex, sharedCount),
+			shareds:  make([]hkvTableShared[K], sharedCount),
+			migrated: make([]bool, sharedCount),
+		}
+		err error
+	)
+
+	for i := range shareds.shareds {
+		err = shareds.shareds[i].init(p.indexType, p.mallocOffheapDriver())
+		if err != nil {
+			for k := 0; k < i; k++ {
+				shareds.shareds[k].reset()
+			}
+			return nil, err
+		}
+	}
+
+	return shareds, nil
+}
+
+func (p *HKVTable[K]) loadShareds() *hkvTableShareds[K] {
+	return p.shareds.Load()
+}
+
+// SharedCount returns the number of shareds of the HKVTable, the new one once
+// Reshard is done
+func (p *HKVTable[K]) SharedCount() uint32 {
+	return p.loadShareds().count
+}
+
+// lockShared locks the shared holding the key of hash, for write if isWrite,
+// following the shareds it is migrated to by Reshard
+func (p *HKVTable[K]) lockShared(hash uint64, isWrite bool) (*hkvTableShared[K], *sync.RWMutex) {
+	var (
+		shareds     = p.loadShareds()
+		sharedIndex int
+		rwMutex     *sync.RWMutex
+	)
+
+	for {
+		sharedIndex = shareds.index(hash)
+		rwMutex = &shareds.rwMutexs[sharedIndex]
+		lockRWMutex(rwMutex, isWrite)
+		if shareds.migrated[sharedIndex] == false {
+			return &shareds.shareds[sharedIndex], rwMutex
+		}
+		unlockRWMutex(rwMutex, isWrite)
+		shareds = shareds.next
+	}
+}
+
+// sharedsChain returns the shareds holding objects, the current ones and the
+// ones Reshard migrates them to. The reshardGate must be held by a scan.
+func (p *HKVTable[K]) sharedsChain() []*hkvTableShareds[K] {
+	var shareds = p.loadShareds()
+	if shareds.next != nil {
+		return []*hkvTableShareds[K]{shareds, shareds.next}
+	}
+	return []*hkvTableShareds[K]{shareds}
+}
+
+// forEachSharedObject invokes fn on the objects of the shared sharedIndex of
+// shareds with its rwMutex read locked, nothing if it is migrated.
+// The reshardGate must be held by a scan.
+func (p *HKVTable[K]) forEachSharedObject(shareds *hkvTableShareds[K], sharedIndex int,
+	fn func(objKey K, uObject HKVTableObjectUPtr[K])) {
+	shareds.rwMutexs[sharedIndex].RLock()
+	if shareds.migrated[sharedIndex] == false {
+		shareds.shareds[sharedIndex].forEach(fn)
+	}
+	shareds.rwMutexs[sharedIndex].RUnlock()
+}
+
+// Reshard moves the objects of the HKVTable to sharedCount new shareds, one
+// shared at a time, while objects keep being got, alloced and deleted.
+// ForEach, Scan, Stats and SweepExpiredObjects hold back Reshard between two
+// shareds, so scanFunc must not call Reshard.
+// If a shared can not be migrated, as when an offheap index can not be
+// malloced, its objects stay where they are and Reshard returns the error,
+// the next Reshard resumes the migration first.
+func (p *HKVTable[K]) Reshard(sharedCount uint32) error {
+	var (
+		shareds *hkvTableShareds[K]
+		next    *hkvTableShareds[K]
+		err     error
+	)
+
+	if sharedCount == 0 {
+		return ErrSharedCountInvalid
+	}
+
+	p.reshardMutex.Lock()
+	shareds = p.loadShareds()
+	if shareds.next == nil {
+		if shareds.count == sharedCount {
+			p.reshardMutex.Unlock()
+			return nil
+		}
+		next, err = p.newShareds(sharedCount)
+		if err != nil {
+			p.reshardMutex.Unlock()
+			return err
+		}
+		p.reshardGate.beginMigrate()
+		shareds.next = next
+		p.reshardGate.endMigrate()
+	}
+
+	for sharedIndex := range shareds.shareds {
+		p.reshardGate.beginMigrate()
+		err = p.migrateShared(shareds, sharedIndex)
+		p.reshardGate.endMigrate()
+		if err != nil {
+			p.reshardMutex.Unlock()
+			return err
+		}
+	}
+
+	p.reshardGate.beginMigrate()
+	p.shareds.Store(shareds.next)
+	p.reshardGate.endMigrate()
+	p.reshardMutex.Unlock()
+
+	// a resumed migration may be to another sharedCount
+	return p.Reshard(sharedCount)
+}
+
+// migrateShared moves the objects of the shared sharedIndex of shareds to
+// shareds.next, keys of the shared are locked meanwhile
+func (p *HKVTable[K]) migrateShared(shareds *hkvTableShareds[K], sharedIndex int) error {
+	var (
+		next       = shareds.next
+		movedKeys  []K
+		movedIndex int
+		err        error
+	)
+
+	shareds.rwMutexs[sharedIndex].Lock()
+	if shareds.migrated[sharedIndex] {
+		shareds.rwMutexs[sharedIndex].Unlock()
+		return nil
+	}
+
+	shareds.shareds[sharedIndex].forEach(func(objKey K, uObject HKVTableObjectUPtr[K]) {
+		if err != nil {
+			return
+		}
+		hash := p.hasher(objKey)
+		movedIndex = next.index(hash)
+		next.rwMutexs[movedIndex].Lock()
+		err = next.shareds[movedIndex].set(objKey, hash, uObject)
+		next.rwMutexs[movedIndex].Unlock()
+		if err == nil {
+			movedKeys = append(movedKeys, objKey)
+		}
+	})
+
+	if err != nil {
+		for _, objKey := range movedKeys {
+			hash := p.hasher(objKey)
+			movedIndex = next.index(hash)
+			next.rwMutexs[movedIndex].Lock()
+			next.shareds[movedIndex].delete(objKey, hash)
+			next.rwMutexs[movedIndex].Unlock()
+		}
+		shareds.rwMutexs[sharedIndex].Unlock()
+		return err
+	}
+
+	shareds.migrated[sharedIndex] = true
+	shareds.shareds[sharedIndex].reset()
+	shareds.rwMutexs[sharedIndex].Unlock()
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package offheap
+
+// HKVTableScanFunc is invoked on an object while it is read acquired,
+// iteration stops if it returns false.
+// Deleting or write acquiring the object in HKVTableScanFunc deadlocks, for bulk
+// invalidations the keys are collected and deleted once ForEach or Scan returns.
+type HKVTableScanFunc[K comparable] func(objKey K, uObject uintptr) bool
+
+type hkvTableScanItem[K comparable] struct {
+	objKey  K
+	uObject HKVTableObjectUPtr[K]
+}
+
+// scanShared invokes scanFunc on every object of the shared sharedIndex of shareds,
+// and returns the number of objects visited and whether scanFunc asked to stop.
+// The reshardGate must be held by a scan.
+func (p *HKVTable[K]) scanShared(shareds *hkvTableShareds[K], sharedIndex int,
+	scanFunc HKVTableScanFunc[K]) (int, bool) {
+	var (
+		items   []hkvTableScanItem[K]
+		visited int
+	)
+
+	// objects are acquired out of sharedRWMutex, like in MustGetObjectWithReadAcquire
+	p.forEachSharedObject(shareds, sharedIndex, func(objKey K, uObject HKVTableObjectUPtr[K]) {
+		items = append(items, hkvTableScanItem[K]{objKey: objKey, uObject: uObject})
+	})
+
+	for _, item := range items {
+		item.uObject.Ptr().ReadAcquire()
+		if p.checkObject(item.uObject, item.objKey) == false {
+			// deleted since listed
+			item.uObject.Ptr().ReadRelease()
+			continue
+		}
+
+		visited++
+		isContinue := scanFunc(item.objKey, uintptr(item.uObject))
+		item.uObject.Ptr().ReadRelease()
+		if isContinue == false {
+			return visited, true
+		}
+	}
+
+	return visited, false
+}
+
+// ForEach invokes scanFunc on every object of the HKVTable until it returns false.
+// Each object is read acquired during scanFunc, objects deleted concurrently
+// are skipped and objects added concurrently may be missed.
+// Reshard waits for ForEach to return.
+func (p *HKVTable[K]) ForEach(scanFunc HKVTableScanFunc[K]) {
+	p.reshardGate.beginScan()
+	for _, shareds := range p.sharedsChain() {
+		for sharedIndex := range shareds.shareds {
+			if _, isStopped := p.scanShared(shareds, sharedIndex, scanFunc); isStopped {
+				p.reshardGate.endScan()
+				return
+			}
+		}
+	}
+	p.reshardGate.endScan()
+}
+
+// Scan is ForEach in steps: it starts at cursor, 0 at first, and invokes scanFunc
+// on whole shareds until at least count objects are visited. It returns the cursor
+// of the next step, which is 0 once every shared is scanned or scanFunc returns false.
+// Objects may be missed or visited twice if Reshard runs between two steps.
+func (p *HKVTable[K]) Scan(cursor uint64, count int, scanFunc HKVTableScanFunc[K]) uint64 {
+	var (
+		// the cursor runs through the shareds of the chain one after the other,
+		// those of shareds start at sharedsCursor
+		sharedsCursor uint64
+		visited       int
+		isStopped     bool
+	)
+
+	p.reshardGate.beginScan()
+	for _, shareds := range p.sharedsChain() {
+		for ; cursor < sharedsCursor+uint64(shareds.count) && visited < count; cursor++ {
+			var sharedVisited int
+			sharedVisited, isStopped = p.scanShared(shareds, int(cursor-sharedsCursor), scanFunc)
+			visited += sharedVisited
+			if isStopped {
+				p.reshardGate.endScan()
+				return 0
+			}
+		}
+		sharedsCursor += uint64(shareds.count)
+	}
+	p.reshardGate.endScan()
+
+	if cursor >= sharedsCursor {
+		return 0
+	}
+	return cursor
+}
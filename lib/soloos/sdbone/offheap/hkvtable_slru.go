@@ -0,0 +1,121 @@
+package offheap
+
+import (
+	"container/list"
+	"sync"
+)
+
+const (
+	DefaultHKVTableSLRUProtectedRatio = 0.8
+
+	hkvTableSLRUProbation = 0
+	hkvTableSLRUProtected = 1
+)
+
+// HKVTableSLRU is the segmented LRU eviction policy: new objects enter the
+// probation segment, objects accessed again move to the protected segment,
+// which is limited to ProtectedRatio of objectsLimit, or of the objects of the
+// HKVTable if objectsLimit is -1, and pushes its least recently used objects
+// back to probation. Victims are the least recently
+// used objects of probation first.
+type HKVTableSLRU struct {
+	// ProtectedRatio is DefaultHKVTableSLRUProtectedRatio if 0
+	ProtectedRatio float64
+
+	mutex          sync.Mutex
+	protectedLimit int
+	probation      list.List
+	protected      list.List
+	elements       map[uintptr]*list.Element
+}
+
+var _ = HKVTableEvictionPolicy(&HKVTableSLRU{})
+
+func (p *HKVTableSLRU) Init(objectsLimit int32) {
+	if p.ProtectedRatio == 0 {
+		p.ProtectedRatio = DefaultHKVTableSLRUProtectedRatio
+	}
+	p.protectedLimit = -1
+	if objectsLimit != -1 {
+		p.protectedLimit = int(float64(objectsLimit) * p.ProtectedRatio)
+	}
+	p.probation.Init()
+	p.protected.Init()
+	p.elements = make(map[uintptr]*list.Element)
+}
+
+func (p *HKVTableSLRU) OnInsert(uObject uintptr) {
+	p.mutex.Lock()
+	GetHKVTableEvictionMeta(uObject).Slot = hkvTableSLRUProbation
+	p.elements[uObject] = p.probation.PushFront(uObject)
+	p.mutex.Unlock()
+}
+
+func (p *HKVTableSLRU) OnAccess(uObject uintptr) {
+	var (
+		meta    = GetHKVTableEvictionMeta(uObject)
+		element *list.Element
+	)
+
+	p.mutex.Lock()
+	element = p.elements[uObject]
+	if element == nil {
+		p.mutex.Unlock()
+		return
+	}
+
+	if meta.Slot == hkvTableSLRUProtected {
+		p.protected.MoveToFront(element)
+		p.mutex.Unlock()
+		return
+	}
+
+	p.probation.Remove(element)
+	meta.Slot = hkvTableSLRUProtected
+	p.elements[uObject] = p.protected.PushFront(uObject)
+	if p.protected.Len() > p.protectedObjectsLimit() {
+		element = p.protected.Back()
+		p.protected.Remove(element)
+		uDemoted := element.Value.(uintptr)
+		GetHKVTableEvictionMeta(uDemoted).Slot = hkvTableSLRUProbation
+		p.elements[uDemoted] = p.probation.PushFront(uDemoted)
+	}
+	p.mutex.Unlock()
+}
+
+// protectedObjectsLimit returns the limit of the protected segment, the objects
+// of a HKVTable without objectsLimit, such as one of a MemoryBudget, are counted
+func (p *HKVTableSLRU) protectedObjectsLimit() int {
+	if p.protectedLimit == -1 {
+		return int(float64(len(p.elements)) * p.ProtectedRatio)
+	}
+	return p.protectedLimit
+}
+
+func (p *HKVTableSLRU) OnDelete(uObject uintptr) {
+	p.mutex.Lock()
+	if element := p.elements[uObject]; element != nil {
+		if GetHKVTableEvictionMeta(uObject).Slot == hkvTableSLRUProtected {
+			p.protected.Remove(element)
+		} else {
+			p.probation.Remove(element)
+		}
+		delete(p.elements, uObject)
+	}
+	p.mutex.Unlock()
+}
+
+func (p *HKVTableSLRU) Victim(isEvictable func(uObject uintptr) bool) uintptr {
+	p.mutex.Lock()
+	for _, segment := range []*list.List{&p.probation, &p.protected} {
+		for element := segment.Back(); element != nil; element = element.Prev() {
+			if uObject := element.Value.(uintptr); isEvictable(uObject) {
+				p.mutex.Unlock()
+				return uObject
+			}
+		}
+	}
+	p.mutex.Unlock()
+
+	return 0
+}
@@ -0,0 +1,368 @@
+package offheap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// snapshot format, integers are little endian:
+//
+//	header: magic uint32, version uint32, keySize uint32 (0 for string keys), objectSize uint32
+//	record: 1 byte, key, expireAt int64, version uint64, the bytes of the object after
+//	        its HKVTableObject, then the value of the object, its length uint32 and its bytes
+//	        a string key is its length uint32 then its bytes, other keys are their keySize bytes
+//	end:    0 byte, objectsNum uint64, crc32 IEEE uint32 of everything before it
+const (
+	HKVTableSnapshotMagic   = uint32(0x53564b48) // "HKVS"
+	HKVTableSnapshotVersion = uint32(1)
+
+	// the longest string key and value of a snapshot, longer lengths are corrupted
+	HKVTableSnapshotMaxKeySize   = 64 << 10
+	HKVTableSnapshotMaxValueSize = 1 << 30
+
+	hkvTableSnapshotRecord = byte(1)
+	hkvTableSnapshotEnd    = byte(0)
+)
+
+// hkvTableSnapshotKeySize returns the size of the binary image of K, 0 for
+// strings, and false if K can not be snapshotted because it holds pointers
+func hkvTableSnapshotKeySize[K comparable]() (int, bool) {
+	var keyType = reflect.TypeOf((*K)(nil)).Elem()
+	if keyType.Kind() == reflect.String {
+		return 0, true
+	}
+	return int(keyType.Size()), isPointerFreeType(keyType)
+}
+
+func isPointerFreeType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	case reflect.Array:
+		return isPointerFreeType(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if isPointerFreeType(t.Field(i).Type) == false {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (p *HKVTable[K]) payloadSize() int {
+	return p.objectSize - int(unsafe.Sizeof(HKVTableObject[K]{}))
+}
+
+// payload returns the bytes of the object after its HKVTableObject
+func (p *HKVTable[K]) payload(uObject uintptr) []byte {
+	var payloadSize = p.payloadSize()
+	if payloadSize == 0 {
+		return nil
+	}
+	return (*[1 << 40]byte)(unsafe.Pointer(uObject + unsafe.Sizeof(HKVTableObject[K]{})))[:payloadSize:payloadSize]
+}
+
+type hkvTableSnapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	buf [8]byte
+	err error
+}
+
+func (p *hkvTableSnapshotWriter) write(bytes []byte) {
+	if p.err != nil {
+		return
+	}
+	p.crc.Write(bytes)
+	_, p.err = p.w.Write(bytes)
+}
+
+func (p *hkvTableSnapshotWriter) writeUint32(v uint32) {
+	binary.LittleEndian.PutUint32(p.buf[:4], v)
+	p.write(p.buf[:4])
+}
+
+func (p *hkvTableSnapshotWriter) writeUint64(v uint64) {
+	binary.LittleEndian.PutUint64(p.buf[:8], v)
+	p.write(p.buf[:8])
+}
+
+type hkvTableSnapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	buf [8]byte
+	err error
+}
+
+func (p *hkvTableSnapshotReader) read(bytes []byte) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = io.ReadFull(p.r, bytes)
+	if p.err == io.EOF {
+		p.err = io.ErrUnexpectedEOF
+	}
+	p.crc.Write(bytes)
+}
+
+// skip reads n bytes without keeping them
+func (p *hkvTableSnapshotReader) skip(n int64) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = io.CopyN(p.crc, p.r, n)
+	if p.err == io.EOF {
+		p.err = io.ErrUnexpectedEOF
+	}
+}
+
+func (p *hkvTableSnapshotReader) readUint32() uint32 {
+	p.read(p.buf[:4])
+	return binary.LittleEndian.Uint32(p.buf[:4])
+}
+
+func (p *hkvTableSnapshotReader) readUint64() uint64 {
+	p.read(p.buf[:8])
+	return binary.LittleEndian.Uint64(p.buf[:8])
+}
+
+// Snapshot writes the image of every key of the HKVTable, with the deadline
+// and the bytes of its object, to w. Each object is read acquired while it is
+// written, objects added or deleted concurrently may be missed.
+// It returns ErrUnknownKeyType if K holds pointers other than a string,
+// ErrKeyTooLong or ErrValueTooLong if a key or a value is longer than
+// HKVTableSnapshotMaxKeySize or HKVTableSnapshotMaxValueSize.
+func (p *HKVTable[K]) Snapshot(w io.Writer) error {
+	var (
+		snapshotWriter = hkvTableSnapshotWriter{w: bufio.NewWriter(w), crc: crc32.NewIEEE()}
+		keySize, isOK  = hkvTableSnapshotKeySize[K]()
+		objectsNum     uint64
+	)
+
+	if isOK == false {
+		return ErrUnknownKeyType
+	}
+
+	snapshotWriter.writeUint32(HKVTableSnapshotMagic)
+	snapshotWriter.writeUint32(HKVTableSnapshotVersion)
+	snapshotWriter.writeUint32(uint32(keySize))
+	snapshotWriter.writeUint32(uint32(p.objectSize))
+
+	p.ForEach(func(objKey K, uObject uintptr) bool {
+		snapshotWriter.write([]byte{hkvTableSnapshotRecord})
+		if keySize == 0 {
+			str := *(*string)(unsafe.Pointer(&objKey))
+			if len(str) > HKVTableSnapshotMaxKeySize {
+				snapshotWriter.err = ErrKeyTooLong
+				return false
+			}
+			snapshotWriter.writeUint32(uint32(len(str)))
+			snapshotWriter.write([]byte(str))
+		} else {
+			snapshotWriter.write((*[1 << 30]byte)(unsafe.Pointer(&objKey))[:keySize:keySize])
+		}
+		snapshotWriter.writeUint64(uint64(HKVTableObjectUPtr[K](uObject).Ptr().ExpireAt))
+		snapshotWriter.writeUint64(HKVTableObjectUPtr[K](uObject).Ptr().Version)
+		snapshotWriter.write(p.payload(uObject))
+		value := OBytesToBytes(p.ObjectValue(uObject))
+		if len(value) > HKVTableSnapshotMaxValueSize {
+			snapshotWriter.err = ErrValueTooLong
+			return false
+		}
+		snapshotWriter.writeUint32(uint32(len(value)))
+		snapshotWriter.write(value)
+		objectsNum++
+		return snapshotWriter.err == nil
+	})
+
+	snapshotWriter.write([]byte{hkvTableSnapshotEnd})
+	snapshotWriter.writeUint64(objectsNum)
+	snapshotWriter.writeUint32(snapshotWriter.crc.Sum32())
+	if snapshotWriter.err != nil {
+		return snapshotWriter.err
+	}
+
+	return snapshotWriter.w.Flush()
+}
+
+// Restore puts the objects of a snapshot written by Snapshot in the HKVTable,
+// which should be freshly created with the same objectSize and K.
+// Objects expired since the snapshot are skipped, the others keep their version.
+// The snapshot is read twice, its records are put only once its checksum is
+// verified, r is read in memory first if it is not an io.ReadSeeker.
+// It returns ErrHKVTableSnapshotInvalid if the snapshot is not of a such
+// HKVTable, ErrHKVTableSnapshotChecksum if it is corrupted, no object is
+// restored then.
+func (p *HKVTable[K]) Restore(r io.Reader) error {
+	var (
+		readSeeker, isSeeker = r.(io.ReadSeeker)
+		_, isOK              = hkvTableSnapshotKeySize[K]()
+		start                int64
+		snapshotBytes        []byte
+		err                  error
+	)
+
+	if isOK == false {
+		return ErrUnknownKeyType
+	}
+
+	if isSeeker == false {
+		snapshotBytes, err = io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		readSeeker = bytes.NewReader(snapshotBytes)
+	}
+	start, err = readSeeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	err = p.restore(readSeeker, false)
+	if err != nil {
+		return err
+	}
+	_, err = readSeeker.Seek(start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	return p.restore(readSeeker, true)
+}
+
+// restore reads the snapshot of r and checks it, the records are put in the
+// HKVTable if isApply, else they are skipped without holding them in memory
+func (p *HKVTable[K]) restore(r io.Reader, isApply bool) error {
+	var (
+		snapshotReader     = hkvTableSnapshotReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+		keySize, _         = hkvTableSnapshotKeySize[K]()
+		flag               [1]byte
+		keyBytes           []byte
+		keyLen             uint32
+		objKey             K
+		snapshotKeySize    uint32
+		snapshotObjectSize uint32
+		expireAt           int64
+		objectVersion      uint64
+		payload            []byte
+		value              []byte
+		valueLen           uint32
+		magic              uint32
+		version            uint32
+		objectsNum         uint64
+		now                int64
+		uObject            uintptr
+		err                error
+	)
+
+	magic = snapshotReader.readUint32()
+	version = snapshotReader.readUint32()
+	snapshotKeySize = snapshotReader.readUint32()
+	snapshotObjectSize = snapshotReader.readUint32()
+	if snapshotReader.err != nil {
+		return snapshotReader.err
+	}
+	if magic != HKVTableSnapshotMagic ||
+		version != HKVTableSnapshotVersion ||
+		snapshotKeySize != uint32(keySize) ||
+		snapshotObjectSize != uint32(p.objectSize) {
+		return ErrHKVTableSnapshotInvalid
+	}
+
+	payload = make([]byte, p.payloadSize())
+	now = time.Now().UnixNano()
+	for {
+		snapshotReader.read(flag[:])
+		if snapshotReader.err != nil {
+			return snapshotReader.err
+		}
+		if flag[0] == hkvTableSnapshotEnd {
+			break
+		}
+		if flag[0] != hkvTableSnapshotRecord {
+			return ErrHKVTableSnapshotInvalid
+		}
+
+		if keySize == 0 {
+			keyLen = snapshotReader.readUint32()
+			if keyLen > HKVTableSnapshotMaxKeySize {
+				return ErrHKVTableSnapshotInvalid
+			}
+			if isApply {
+				keyBytes = make([]byte, keyLen)
+				snapshotReader.read(keyBytes)
+				*(*string)(unsafe.Pointer(&objKey)) = string(keyBytes)
+			} else {
+				snapshotReader.skip(int64(keyLen))
+			}
+		} else {
+			snapshotReader.read((*[1 << 30]byte)(unsafe.Pointer(&objKey))[:keySize:keySize])
+		}
+		expireAt = int64(snapshotReader.readUint64())
+		objectVersion = snapshotReader.readUint64()
+		snapshotReader.read(payload)
+		valueLen = snapshotReader.readUint32()
+		if valueLen > HKVTableSnapshotMaxValueSize {
+			return ErrHKVTableSnapshotInvalid
+		}
+		if isApply {
+			value = make([]byte, valueLen)
+			snapshotReader.read(value)
+		} else {
+			snapshotReader.skip(int64(valueLen))
+		}
+		if snapshotReader.err != nil {
+			return snapshotReader.err
+		}
+		objectsNum++
+
+		if isApply == false || (expireAt != 0 && expireAt <= now) {
+			continue
+		}
+
+		uObject, _, err = p.MustGetObjectWithWriteAcquire(objKey)
+		if err != nil {
+			return err
+		}
+		atomic.StoreInt64(&HKVTableObjectUPtr[K](uObject).Ptr().ExpireAt, expireAt)
+		copy(p.payload(uObject), payload)
+		err = p.SetObjectValue(uObject, value)
+		if err == nil && objectVersion != 0 {
+			p.ensureVersion(objectVersion)
+			HKVTableObjectUPtr[K](uObject).Ptr().Version = objectVersion
+		}
+		HKVTableObjectUPtr[K](uObject).Ptr().WriteRelease()
+		if err != nil {
+			return err
+		}
+	}
+
+	if snapshotReader.readUint64() != objectsNum {
+		if snapshotReader.err != nil {
+			return snapshotReader.err
+		}
+		return ErrHKVTableSnapshotChecksum
+	}
+	crc := snapshotReader.crc.Sum32()
+	if snapshotReader.readUint32() != crc {
+		if snapshotReader.err != nil {
+			return snapshotReader.err
+		}
+		return ErrHKVTableSnapshotChecksum
+	}
+
+	return nil
+}
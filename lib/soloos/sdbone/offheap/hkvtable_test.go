@@ -0,0 +1,1152 @@
+package offheap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"soloos/common/util"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHKVTable(t *testing.T) {
+	testHKVTable(t, HKVTableOptions{})
+	testHKVTable(t, HKVTableOptions{Index: HKVTableIndexOffheap})
+}
+
+func testHKVTable(t *testing.T, options HKVTableOptions) {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithInt64
+		uObject       uintptr
+		loaded        bool
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithInt64("test",
+		int(unsafe.Sizeof(HKVTableObjectWithInt64{})), 2, 4, nil, nil, options)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", kvTable.Name())
+
+	uObject, loaded, err = kvTable.MustGetObjectWithReadAcquire(-5)
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+	assert.Equal(t, int64(-5), HKVTableObjectUPtrWithInt64(uObject).Ptr().ID)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+
+	uObject, loaded, err = kvTable.MustGetObjectWithReadAcquire(-5)
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+
+	for k := int64(0); k < 4; k++ {
+		uObject, _, err = kvTable.MustGetObjectWithReadAcquire(k)
+		assert.NoError(t, err)
+		HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+	}
+	assert.Equal(t, 2, kvTable.Stats().ObjectsNum)
+
+	uObject = kvTable.TryGetObjectWithReadAcquire(3)
+	assert.NotEqual(t, uintptr(0), uObject)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+	kvTable.DeleteObject(3)
+	assert.Equal(t, uintptr(0), kvTable.TryGetObjectWithReadAcquire(3))
+}
+
+func TestHKVTableEvictAcquired(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithInt64
+		uObject       uintptr
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithInt64("test",
+		int(unsafe.Sizeof(HKVTableObjectWithInt64{})), 1, 4, nil, nil)
+	assert.NoError(t, err)
+
+	// the only object is acquired, so none can be evicted
+	uObject, _, err = kvTable.MustGetObjectWithReadAcquire(1)
+	assert.NoError(t, err)
+	_, _, err = kvTable.MustGetObjectWithReadAcquire(2)
+	assert.Equal(t, ErrAllocChunkOurOfLimit, err)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+
+	results := kvTable.MultiMustGetWithReadAcquire([]int64{1, 2})
+	assert.NoError(t, results[0].Err)
+	assert.True(t, results[0].Loaded)
+	assert.Equal(t, ErrAllocChunkOurOfLimit, results[1].Err)
+	HKVTableObjectUPtrWithInt64(results[0].UObject).Ptr().ReadRelease()
+
+	uObject, _, err = kvTable.MustGetObjectWithReadAcquire(2)
+	assert.NoError(t, err)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+	assert.Equal(t, uintptr(0), kvTable.TryGetObjectWithReadAcquire(1))
+}
+
+func TestHKVTableWithHasher(t *testing.T) {
+	type key struct {
+		a, b int32
+	}
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTable[key]
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	_, err = CreateHKVTable[struct{ f float64 }](&offheapDriver, "test",
+		int(unsafe.Sizeof(HKVTableObject[struct{ f float64 }]{})), 16, 4, nil, nil, nil)
+	assert.Equal(t, ErrUnknownKeyType, err)
+
+	_, err = CreateHKVTable[key](&offheapDriver, "small",
+		int(unsafe.Sizeof(HKVTableObject[key]{}))-1, 16, 4,
+		func(k key) uint64 { return uint64(k.a) }, nil, nil)
+	assert.Equal(t, ErrObjectSizeInvalid, err)
+
+	kvTable, err = CreateHKVTable[key](&offheapDriver, "test",
+		int(unsafe.Sizeof(HKVTableObject[key]{})), 16, 4,
+		func(k key) uint64 { return uint64(k.a) }, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, kvTable.getShared(key{a: 7, b: 1}))
+
+	uObject, loaded, err := kvTable.MustGetObjectWithReadAcquire(key{a: 7, b: 1})
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+	assert.Equal(t, key{a: 7, b: 1}, HKVTableObjectUPtr[key](uObject).Ptr().ID)
+	HKVTableObjectUPtr[key](uObject).Ptr().ReadRelease()
+}
+
+func TestDefaultHKVTableHasher(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithInt64
+		sharedsNum    [8]int
+		err           error
+	)
+
+	assert.Equal(t, HashString(1, "abc"), DefaultHKVTableHasherWithSeed[string](1)("abc"))
+	assert.Equal(t, HashBytes(1, []byte{1, 2}), DefaultHKVTableHasherWithSeed[[2]byte](1)([2]byte{1, 2}))
+	assert.Equal(t, HashUint64(1, 7), DefaultHKVTableHasherWithSeed[int32](1)(7))
+	assert.Equal(t, HashUint64(1, 7), DefaultHKVTableHasherWithSeed[uint16](1)(7))
+	assert.Equal(t, HashUint64(1, uint64(1<<64-1)), DefaultHKVTableHasherWithSeed[int8](1)(-1))
+	assert.Nil(t, DefaultHKVTableHasher[float64]())
+
+	assert.NotEqual(t, HashString(1, "abc"), HashString(2, "abc"))
+	assert.NotEqual(t, HashUint64(1, 7), HashUint64(2, 7))
+	for size := 0; size < 128; size++ {
+		bytes := make([]byte, size+1)
+		assert.NotEqual(t, HashBytes(1, bytes[:size]), HashBytes(1, bytes))
+	}
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithInt64("hasher",
+		int(unsafe.Sizeof(HKVTableObjectWithInt64{})), 16, 8, nil, nil,
+		HKVTableOptions{HashSeed: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, HashUint64(1, 7), kvTable.hasher(7))
+	for k := int64(-4096); k < 4096; k++ {
+		sharedIndex := kvTable.getShared(k)
+		assert.True(t, sharedIndex >= 0 && sharedIndex < 8)
+		sharedsNum[sharedIndex]++
+	}
+	for _, sharedNum := range sharedsNum {
+		assert.InDelta(t, 1024, sharedNum, 128)
+	}
+}
+
+func TestHKVTableKeyTypes(t *testing.T) {
+	type paddedKey struct {
+		a int8
+		b int64
+		_ int32
+	}
+	var (
+		offheapDriver OffheapDriver
+		uuidTable     *HKVTableWithBytes16
+		pairTable     *HKVTableWithInt64Bytes16
+		uint64Table   *HKVTableWithUint64
+		sharedsNum    [3][8]int
+		uuid          [16]byte
+		err           error
+	)
+
+	// padding and blank fields are not hashed, like they are not compared by ==
+	var k0, k1 paddedKey
+	k0.a, k0.b = 1, 2
+	*(*[24]byte)(unsafe.Pointer(&k1)) = [24]byte{1: 0xff, 5: 0xff, 16: 0xff}
+	k1.a, k1.b = 1, 2
+	assert.True(t, k0 == k1)
+	assert.Equal(t, DefaultHKVTableHasherWithSeed[paddedKey](1)(k0), DefaultHKVTableHasherWithSeed[paddedKey](1)(k1))
+
+	assert.Equal(t, HashBytes(1, make([]byte, 32)), DefaultHKVTableHasherWithSeed[[32]byte](1)([32]byte{}))
+	assert.NotEqual(t,
+		DefaultHKVTableHasherWithSeed[HKVTableKeyPair[string, string]](1)(HKVTableKeyPair[string, string]{"ab", "c"}),
+		DefaultHKVTableHasherWithSeed[HKVTableKeyPair[string, string]](1)(HKVTableKeyPair[string, string]{"a", "bc"}))
+	assert.Nil(t, DefaultHKVTableHasher[HKVTableKeyPair[int64, float64]]())
+	assert.Nil(t, DefaultHKVTableHasher[[2]*int]())
+
+	assert.NoError(t, offheapDriver.Init())
+	uint64Table, err = offheapDriver.CreateHKVTableWithUint64("uint64",
+		int(unsafe.Sizeof(HKVTableObjectWithUint64{})), 16, 8, nil, nil)
+	assert.NoError(t, err)
+	uuidTable, err = offheapDriver.CreateHKVTableWithBytes16("uuid",
+		int(unsafe.Sizeof(HKVTableObjectWithBytes16{})), 16, 8, nil, nil)
+	assert.NoError(t, err)
+	pairTable, err = offheapDriver.CreateHKVTableWithInt64Bytes16("pair",
+		int(unsafe.Sizeof(HKVTableObjectWithInt64Bytes16{})), 16, 8, nil, nil,
+		HKVTableOptions{Index: HKVTableIndexOffheap})
+	assert.NoError(t, err)
+
+	// sequential ids are spread over shareds
+	for i := 0; i < 8192; i++ {
+		binary.BigEndian.PutUint64(uuid[8:], uint64(i))
+		sharedsNum[0][uint64Table.getShared(uint64(i))]++
+		sharedsNum[1][uuidTable.getShared(uuid)]++
+		sharedsNum[2][pairTable.getShared(HKVTableKeyPair[int64, [16]byte]{First: 1, Second: uuid})]++
+	}
+	for i := range sharedsNum {
+		for _, sharedNum := range sharedsNum[i] {
+			assert.InDelta(t, 1024, sharedNum, 128)
+		}
+	}
+
+	key := HKVTableKeyPair[int64, [16]byte]{First: 7, Second: uuid}
+	uObject, loaded, err := pairTable.MustGetObjectWithReadAcquire(key)
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+	assert.Equal(t, key, HKVTableObjectUPtrWithInt64Bytes16(uObject).Ptr().ID)
+	HKVTableObjectUPtrWithInt64Bytes16(uObject).Ptr().ReadRelease()
+	key.First = 8
+	assert.Equal(t, uintptr(0), pairTable.TryGetObjectWithReadAcquire(key))
+	key.First = 7
+	pairTable.DeleteObject(key)
+	assert.Equal(t, uintptr(0), pairTable.TryGetObjectWithReadAcquire(key))
+}
+
+func BenchmarkHashBytes(b *testing.B) {
+	var bytes [64]byte
+	for n := 0; n < b.N; n++ {
+		HashBytes(1, bytes[:])
+	}
+}
+
+// hashBytesFNV is the 32 bits FNV hash HKVTables used before wyhash
+func hashBytesFNV(bytes []byte) uint64 {
+	hash := uint32(2166136261)
+	const prime32 = uint32(16777619)
+	for i := 0; i < len(bytes); i++ {
+		hash *= prime32
+		hash ^= uint32(bytes[i])
+	}
+	return uint64(hash)
+}
+
+func BenchmarkHashBytesFNV(b *testing.B) {
+	var bytes [64]byte
+	for n := 0; n < b.N; n++ {
+		hashBytesFNV(bytes[:])
+	}
+}
+
+func TestHKVTableForEachAndScan(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithString
+		keys          = make(map[string]bool)
+		cursor        uint64
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithString("test",
+		int(unsafe.Sizeof(HKVTableObjectWithString{})), 128, 8, nil, nil)
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		uObject, _, err := kvTable.MustGetObjectWithReadAcquire(fmt.Sprintf("key%d", i))
+		assert.NoError(t, err)
+		HKVTableObjectUPtrWithString(uObject).Ptr().ReadRelease()
+	}
+
+	kvTable.ForEach(func(objKey string, uObject uintptr) bool {
+		assert.Equal(t, objKey, HKVTableObjectUPtrWithString(uObject).Ptr().ID)
+		assert.Equal(t, int32(1), HKVTableObjectUPtrWithString(uObject).Ptr().GetAccessor())
+		keys[objKey] = true
+		return true
+	})
+	assert.Equal(t, 100, len(keys))
+
+	visited := 0
+	kvTable.ForEach(func(objKey string, uObject uintptr) bool {
+		visited++
+		return visited < 10
+	})
+	assert.Equal(t, 10, visited)
+
+	// bulk invalidation, keys are deleted once ForEach returns
+	var invalidKeys []string
+	kvTable.ForEach(func(objKey string, uObject uintptr) bool {
+		if strings.HasSuffix(objKey, "7") {
+			invalidKeys = append(invalidKeys, objKey)
+		}
+		return true
+	})
+	assert.Equal(t, 10, len(invalidKeys))
+	for _, objKey := range invalidKeys {
+		kvTable.DeleteObject(objKey)
+	}
+	assert.Equal(t, 90, kvTable.Stats().ObjectsNum)
+	for _, objKey := range invalidKeys {
+		uObject, _, err := kvTable.MustGetObjectWithReadAcquire(objKey)
+		assert.NoError(t, err)
+		HKVTableObjectUPtrWithString(uObject).Ptr().ReadRelease()
+	}
+
+	// delete keys while scanning
+	keys = make(map[string]bool)
+	for steps := 0; ; steps++ {
+		cursor = kvTable.Scan(cursor, 10, func(objKey string, uObject uintptr) bool {
+			keys[objKey] = true
+			return true
+		})
+		if steps == 0 {
+			for i := 0; i < 100; i += 2 {
+				kvTable.DeleteObject(fmt.Sprintf("key%d", i))
+			}
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	for i := 1; i < 100; i += 2 {
+		assert.True(t, keys[fmt.Sprintf("key%d", i)])
+	}
+	assert.Equal(t, 50, kvTable.Stats().ObjectsNum)
+}
+
+// hkvTableRandomEviction evicts random objects, as a baseline of hit ratio
+type hkvTableRandomEviction struct {
+	HKVTableCLOCK
+	rand *rand.Rand
+}
+
+func (p *hkvTableRandomEviction) Victim(isEvictable func(uObject uintptr) bool) uintptr {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for i := 0; i < len(p.slots)*2; i++ {
+		uObject := p.slots[p.rand.Intn(len(p.slots))]
+		if uObject != 0 && isEvictable(uObject) {
+			return uObject
+		}
+	}
+	return 0
+}
+
+// hkvTableHitRatio returns the hit ratio of a HKVTable of 256 objects, limited
+// by objectsLimit, or by a MemoryBudget if isBudget
+func hkvTableHitRatio(t *testing.T, evictionPolicy HKVTableEvictionPolicy, isBudget bool) float64 {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithInt64
+		objectSize    = int(unsafe.Sizeof(HKVTableObjectWithInt64{}))
+		objectsLimit  = int32(256)
+		options       = HKVTableOptions{EvictionPolicy: evictionPolicy}
+		zipf          = rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, 10000)
+		hits          int
+		gets          = 100000
+		err           error
+	)
+
+	if isBudget {
+		options.MemoryBudget = new(MemoryBudget)
+		options.MemoryBudget.Init(int64(objectsLimit) * int64(objectSize))
+		objectsLimit = -1
+	}
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithInt64("test",
+		objectSize, objectsLimit, 8, nil, nil, options)
+	assert.NoError(t, err)
+
+	for i := 0; i < gets; i++ {
+		// scans of cold keys, which a good policy does not keep
+		objKey := int64(zipf.Uint64())
+		if i%10 == 0 {
+			objKey = int64(20000 + i)
+		}
+		uObject, loaded, err := kvTable.MustGetObjectWithReadAcquire(objKey)
+		assert.NoError(t, err)
+		if loaded {
+			hits++
+		}
+		HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+	}
+	assert.True(t, kvTable.Stats().ObjectsNum <= 256)
+
+	return float64(hits) / float64(gets)
+}
+
+func TestHKVTableEvictionPolicies(t *testing.T) {
+	randomHitRatio := hkvTableHitRatio(t, &hkvTableRandomEviction{rand: rand.New(rand.NewSource(1))}, false)
+	clockHitRatio := hkvTableHitRatio(t, new(HKVTableCLOCK), false)
+	slruHitRatio := hkvTableHitRatio(t, new(HKVTableSLRU), false)
+	budgetSLRU := new(HKVTableSLRU)
+	budgetSLRUHitRatio := hkvTableHitRatio(t, budgetSLRU, true)
+	t.Logf("hit ratio random:%.3f clock:%.3f slru:%.3f budget slru:%.3f",
+		randomHitRatio, clockHitRatio, slruHitRatio, budgetSLRUHitRatio)
+
+	assert.True(t, clockHitRatio > randomHitRatio)
+	assert.True(t, slruHitRatio > randomHitRatio)
+	assert.True(t, budgetSLRUHitRatio > randomHitRatio)
+	// the protected segment of a table without objectsLimit is not empty
+	assert.True(t, budgetSLRU.protected.Len() > 0)
+	assert.True(t, budgetSLRU.protected.Len() <= len(budgetSLRU.elements))
+}
+
+func TestHKVTableExpire(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithInt64
+		releasedNum   int
+		uObject       uintptr
+		loaded        bool
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithInt64("expire",
+		int(unsafe.Sizeof(HKVTableObjectWithInt64{})), 16, 4,
+		nil, func(uObject uintptr) {
+			releasedNum++
+			HKVTableObjectUPtrWithInt64(uObject).Ptr().SetReleasable()
+		})
+	assert.NoError(t, err)
+
+	uObject, loaded, err = kvTable.MustGetObjectWithReadAcquireTTL(1, time.Millisecond*10)
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+	uObject, loaded, err = kvTable.MustGetObjectWithReadAcquireTTL(1, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+
+	time.Sleep(time.Millisecond * 20)
+	assert.Equal(t, uintptr(0), kvTable.TryGetObjectWithReadAcquire(1))
+	assert.Equal(t, 1, releasedNum)
+	uObject, loaded, err = kvTable.MustGetObjectWithReadAcquire(1)
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+
+	assert.False(t, kvTable.SetExpire(2, time.Now()))
+	for k := int64(2); k < 6; k++ {
+		uObject, _, err = kvTable.MustGetObjectWithReadAcquire(k)
+		assert.NoError(t, err)
+		HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+		assert.True(t, kvTable.SetExpire(k, time.Now().Add(-time.Second)))
+	}
+	assert.False(t, kvTable.SetExpire(5, time.Time{}))
+	uObject, _, err = kvTable.MustGetObjectWithReadAcquireTTL(5, time.Millisecond)
+	assert.NoError(t, err)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+	assert.True(t, kvTable.SetExpire(5, time.Time{}))
+	time.Sleep(time.Millisecond * 2)
+	assert.Equal(t, 3, kvTable.SweepExpiredObjects())
+	assert.Equal(t, 5, releasedNum)
+	assert.Equal(t, 2, kvTable.Stats().ObjectsNum)
+	assert.NotEqual(t, uintptr(0), kvTable.TryGetObjectWithReadAcquire(5))
+	HKVTableObjectUPtrWithInt64(kvTable.TryGetObjectWithReadAcquire(5)).Ptr().ReadRelease()
+	assert.NoError(t, kvTable.Close())
+}
+
+func TestHKVTableExpireSweeper(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithInt64
+		uObject       uintptr
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithInt64("sweeper",
+		int(unsafe.Sizeof(HKVTableObjectWithInt64{})), 16, 4, nil, nil,
+		HKVTableOptions{ExpireSweepInterval: time.Millisecond})
+	assert.NoError(t, err)
+
+	for k := int64(0); k < 8; k++ {
+		uObject, _, err = kvTable.MustGetObjectWithReadAcquireTTL(k, time.Millisecond)
+		assert.NoError(t, err)
+		HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+	}
+	for i := 0; i < 1000 && kvTable.Stats().ObjectsNum > 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 0, kvTable.Stats().ObjectsNum)
+	assert.NoError(t, kvTable.Close())
+	assert.Nil(t, offheapDriver.GetRawChunkPool(kvTable.chunkPool.ID))
+}
+
+func TestHKVTableOffheapIndex(t *testing.T) {
+	var (
+		index      hkvTableOffheapIndex[int64]
+		objectSize = unsafe.Sizeof(HKVTableObject[int64]{})
+		objectsNum = 4096
+		uObjects   uintptr
+		keys       = make(map[int64]bool)
+		hash       = func(k int64) uint64 { return uint64(k % 7) }
+		err        error
+	)
+
+	// the index only holds offheap objects
+	uObjects, err = DefaultOffheapDriver.Malloc(objectsNum * int(objectSize))
+	assert.NoError(t, err)
+	for i := 0; i < objectsNum; i++ {
+		HKVTableObjectUPtr[int64](uObjects + uintptr(i)*objectSize).Ptr().ID = int64(i)
+	}
+
+	assert.NoError(t, index.init(&DefaultOffheapDriver))
+	for n := 0; n < 100000; n++ {
+		k := rand.Int63n(int64(objectsNum))
+		uObject := HKVTableObjectUPtr[int64](uObjects + uintptr(k)*objectSize)
+		switch rand.Intn(3) {
+		case 0:
+			assert.NoError(t, index.set(k, hash(k), uObject))
+			keys[k] = true
+		case 1:
+			index.delete(k, hash(k))
+			delete(keys, k)
+		case 2:
+			got, exists := index.get(k, hash(k))
+			assert.Equal(t, keys[k], exists)
+			if exists {
+				assert.Equal(t, uObject, got)
+			}
+		}
+	}
+	assert.Equal(t, len(keys), index.len)
+
+	index.forEach(func(objKey int64, uObject HKVTableObjectUPtr[int64]) {
+		assert.True(t, keys[objKey])
+		delete(keys, objKey)
+	})
+	assert.Equal(t, 0, len(keys))
+	index.free()
+	DefaultOffheapDriver.Free(uObjects)
+}
+
+func benchmarkHKVTableGet(b *testing.B, options HKVTableOptions) {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithInt64
+		keysNum       = int64(1 << 16)
+		uObject       uintptr
+		err           error
+	)
+
+	util.AssertErrIsNil(offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithInt64("bench",
+		int(unsafe.Sizeof(HKVTableObjectWithInt64{})), int32(keysNum), 32, nil, nil, options)
+	util.AssertErrIsNil(err)
+	for k := int64(0); k < keysNum; k++ {
+		uObject, _, err = kvTable.MustGetObjectWithReadAcquire(k)
+		util.AssertErrIsNil(err)
+		HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var k int64
+		for pb.Next() {
+			uObject := kvTable.TryGetObjectWithReadAcquire(k & (keysNum - 1))
+			HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+			k += 7
+		}
+	})
+}
+
+func BenchmarkHKVTableGetWithMapIndex(b *testing.B) {
+	benchmarkHKVTableGet(b, HKVTableOptions{})
+}
+
+func BenchmarkHKVTableGetWithOffheapIndex(b *testing.B) {
+	benchmarkHKVTableGet(b, HKVTableOptions{Index: HKVTableIndexOffheap})
+}
+
+func TestHKVTableSnapshot(t *testing.T) {
+	type object struct {
+		HKVTableObjectWithString
+		Value int64
+	}
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithString
+		restored      *HKVTableWithString
+		snapshot      bytes.Buffer
+		uObject       uintptr
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithString("snapshot",
+		int(unsafe.Sizeof(object{})), 128, 4, nil, nil)
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		uObject, _, err = kvTable.MustGetObjectWithReadAcquire(fmt.Sprint(i))
+		assert.NoError(t, err)
+		(*object)(unsafe.Pointer(uObject)).Value = int64(i)
+		HKVTableObjectUPtrWithString(uObject).Ptr().ReadRelease()
+	}
+	assert.True(t, kvTable.SetExpire("0", time.Now().Add(time.Millisecond)))
+	assert.True(t, kvTable.SetExpire("1", time.Now().Add(time.Hour)))
+	assert.NoError(t, kvTable.Snapshot(&snapshot))
+	time.Sleep(time.Millisecond * 2)
+
+	restored, err = offheapDriver.CreateHKVTableWithString("restored",
+		int(unsafe.Sizeof(object{})), 128, 4, nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, restored.Restore(bytes.NewReader(snapshot.Bytes())))
+	assert.Equal(t, 99, restored.Stats().ObjectsNum)
+	for i := 1; i < 100; i++ {
+		uObject = restored.TryGetObjectWithReadAcquire(fmt.Sprint(i))
+		assert.NotEqual(t, uintptr(0), uObject)
+		assert.Equal(t, int64(i), (*object)(unsafe.Pointer(uObject)).Value)
+		HKVTableObjectUPtrWithString(uObject).Ptr().ReadRelease()
+	}
+	assert.NotEqual(t, int64(0), HKVTableObjectUPtrWithString(
+		restored.loadShareds().shareds[restored.getShared("1")].objects["1"]).Ptr().ExpireAt)
+
+	corrupted := append([]byte(nil), snapshot.Bytes()...)
+	// the payload of the first record, after its header, flag, key, expireAt and version
+	corrupted[16+1+4+int(corrupted[17])+8+8] ^= 0xff
+	restored, err = offheapDriver.CreateHKVTableWithString("corrupted",
+		int(unsafe.Sizeof(object{})), 128, 4, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrHKVTableSnapshotChecksum, restored.Restore(bytes.NewReader(corrupted)))
+	assert.Equal(t, io.ErrUnexpectedEOF, restored.Restore(bytes.NewReader(snapshot.Bytes()[:snapshot.Len()-1])))
+	assert.Equal(t, ErrHKVTableSnapshotChecksum, restored.Restore(bytes.NewBuffer(corrupted)))
+	assert.Equal(t, 0, restored.Stats().ObjectsNum)
+
+	// the length of the first key
+	corrupted = append([]byte(nil), snapshot.Bytes()...)
+	binary.LittleEndian.PutUint32(corrupted[16+1:], 0xffffffff)
+	assert.Equal(t, ErrHKVTableSnapshotInvalid, restored.Restore(bytes.NewReader(corrupted)))
+	assert.Equal(t, 0, restored.Stats().ObjectsNum)
+
+	kvTableInt64, err := offheapDriver.CreateHKVTableWithInt64("int64",
+		int(unsafe.Sizeof(object{})), 128, 4, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrHKVTableSnapshotInvalid, kvTableInt64.Restore(bytes.NewReader(snapshot.Bytes())))
+}
+
+func TestHKVTableWriteAcquire(t *testing.T) {
+	type object struct {
+		HKVTableObjectWithInt64
+		Value int64
+	}
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithInt64
+		waitGroup     sync.WaitGroup
+		uObject       uintptr
+		loaded        bool
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithInt64("write",
+		int(unsafe.Sizeof(object{})), 16, 4, nil, nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, uintptr(0), kvTable.TryGetObjectWithWriteAcquire(1))
+	for i := 0; i < 8; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for n := 0; n < 1000; n++ {
+				uObject, _, err := kvTable.MustGetObjectWithWriteAcquire(1)
+				assert.NoError(t, err)
+				(*object)(unsafe.Pointer(uObject)).Value++
+				HKVTableObjectUPtrWithInt64(uObject).Ptr().WriteRelease()
+			}
+		}()
+	}
+	waitGroup.Wait()
+	uObject = kvTable.TryGetObjectWithWriteAcquire(1)
+	assert.Equal(t, int64(8000), (*object)(unsafe.Pointer(uObject)).Value)
+	assert.True(t, kvTable.DowngradeObjectAcquire(1, uObject))
+	isAcquired, isWritten := kvTable.UpgradeObjectAcquire(1, uObject)
+	assert.True(t, isAcquired)
+	assert.False(t, isWritten)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().WriteRelease()
+
+	// a writer between the read release and the write acquire of the upgrade
+	uObject = kvTable.TryGetObjectWithReadAcquire(1)
+	written := make(chan struct{})
+	go func() {
+		uObject := kvTable.TryGetObjectWithWriteAcquire(1)
+		(*object)(unsafe.Pointer(uObject)).Value++
+		HKVTableObjectUPtrWithInt64(uObject).Ptr().WriteRelease()
+		close(written)
+	}()
+	for HKVTableObjectUPtrWithInt64(uObject).Ptr().GetAccessor() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	// lets the writer block on the lock of uObject
+	time.Sleep(time.Millisecond * 10)
+	isAcquired, isWritten = kvTable.UpgradeObjectAcquire(1, uObject)
+	<-written
+	assert.True(t, isAcquired)
+	assert.True(t, isWritten)
+	assert.Equal(t, int64(8001), (*object)(unsafe.Pointer(uObject)).Value)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().WriteRelease()
+
+	uObject, loaded, err = kvTable.MustGetObjectWithReadAcquire(1)
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	deleted := make(chan struct{})
+	go func() {
+		kvTable.DeleteObject(1)
+		close(deleted)
+	}()
+	for HKVTableObjectUPtrWithInt64(uObject).Ptr().GetAccessor() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	isAcquired, _ = kvTable.UpgradeObjectAcquire(1, uObject)
+	assert.False(t, isAcquired)
+	<-deleted
+	assert.Equal(t, int32(0), HKVTableObjectUPtrWithInt64(uObject).Ptr().GetAccessor())
+	assert.Equal(t, uintptr(0), kvTable.TryGetObjectWithReadAcquire(1))
+}
+
+func TestHKVTableValue(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithString
+		restored      *HKVTableWithString
+		snapshot      bytes.Buffer
+		waitGroup     sync.WaitGroup
+		uObject       uintptr
+		value         OBytes
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithString("value",
+		int(unsafe.Sizeof(HKVTableObjectWithString{})), 16, 4, nil, nil)
+	assert.NoError(t, err)
+
+	uObject, _ = kvTable.GetValueWithReadAcquire("a")
+	assert.Equal(t, uintptr(0), uObject)
+	for _, size := range []int{0, 10, 1000, 8, 100000, 3} {
+		assert.NoError(t, kvTable.SetValue("a", bytes.Repeat([]byte{byte(size)}, size)))
+		uObject, value = kvTable.GetValueWithReadAcquire("a")
+		assert.NotEqual(t, uintptr(0), uObject)
+		assert.Equal(t, size, value.Len)
+		assert.True(t, value.Cap >= value.Len)
+		assert.Equal(t, bytes.Repeat([]byte{byte(size)}, size), append([]byte{}, OBytesToBytes(value)...))
+		HKVTableObjectUPtrWithString(uObject).Ptr().ReadRelease()
+	}
+
+	assert.NoError(t, kvTable.SetValue("b", []byte("hello")))
+	assert.NoError(t, kvTable.Snapshot(&snapshot))
+	restored, err = offheapDriver.CreateHKVTableWithString("restored",
+		int(unsafe.Sizeof(HKVTableObjectWithString{})), 16, 4, nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, restored.Restore(&snapshot))
+	uObject, value = restored.GetValueWithReadAcquire("b")
+	assert.Equal(t, "hello", string(OBytesToBytes(value)))
+	HKVTableObjectUPtrWithString(uObject).Ptr().ReadRelease()
+	assert.NoError(t, restored.Close())
+
+	kvTable.DeleteObject("a")
+	assert.Equal(t, uintptr(0), kvTable.TryGetObjectWithReadAcquire("a"))
+
+	for i := 0; i < 4; i++ {
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			for n := 0; n < 1000; n++ {
+				if i%2 == 0 {
+					assert.NoError(t, kvTable.SetValue("c", bytes.Repeat([]byte{byte(n)}, n%300)))
+					continue
+				}
+				uObject, value := kvTable.GetValueWithReadAcquire("c")
+				if uObject == 0 {
+					continue
+				}
+				valueBytes := OBytesToBytes(value)
+				for k := range valueBytes {
+					assert.Equal(t, valueBytes[0], valueBytes[k])
+				}
+				HKVTableObjectUPtrWithString(uObject).Ptr().ReadRelease()
+			}
+		}(i)
+	}
+	waitGroup.Wait()
+	assert.NoError(t, kvTable.Close())
+}
+
+func TestHKVTableBatch(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithInt64
+		keys          []int64
+		uObjects      []uintptr
+		results       []HKVTableBatchResult
+		deleted       []bool
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithInt64("batch",
+		int(unsafe.Sizeof(HKVTableObjectWithInt64{})), 1024, 8, nil, nil)
+	assert.NoError(t, err)
+
+	for k := int64(0); k < 100; k++ {
+		keys = append(keys, k)
+	}
+	uObject, _, err := kvTable.MustGetObjectWithReadAcquire(7)
+	assert.NoError(t, err)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+
+	results = kvTable.MultiMustGetWithReadAcquire(keys)
+	for i, result := range results {
+		assert.NoError(t, result.Err)
+		assert.Equal(t, keys[i] == 7, result.Loaded)
+		assert.Equal(t, keys[i], HKVTableObjectUPtrWithInt64(result.UObject).Ptr().ID)
+		HKVTableObjectUPtrWithInt64(result.UObject).Ptr().ReadRelease()
+	}
+	assert.Equal(t, 100, kvTable.Stats().ObjectsNum)
+
+	uObjects = kvTable.MultiGetWithReadAcquire([]int64{3, 1000, 5, 3})
+	assert.Equal(t, int64(3), HKVTableObjectUPtrWithInt64(uObjects[0]).Ptr().ID)
+	assert.Equal(t, uintptr(0), uObjects[1])
+	assert.Equal(t, int64(5), HKVTableObjectUPtrWithInt64(uObjects[2]).Ptr().ID)
+	assert.Equal(t, uintptr(0), uObjects[3])
+	for _, uObject := range uObjects {
+		if uObject != 0 {
+			HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+		}
+	}
+
+	// a repeated key is acquired once, MultiDelete would block on a second read hold
+	results = kvTable.MultiMustGetWithReadAcquire([]int64{2000, 7, 2000, 7})
+	assert.Equal(t, int64(2000), HKVTableObjectUPtrWithInt64(results[0].UObject).Ptr().ID)
+	assert.Equal(t, int64(7), HKVTableObjectUPtrWithInt64(results[1].UObject).Ptr().ID)
+	assert.Equal(t, HKVTableBatchResult{Loaded: false}, results[2])
+	assert.Equal(t, HKVTableBatchResult{Loaded: true}, results[3])
+	HKVTableObjectUPtrWithInt64(results[0].UObject).Ptr().ReadRelease()
+	HKVTableObjectUPtrWithInt64(results[1].UObject).Ptr().ReadRelease()
+	assert.Equal(t, []bool{true, false}, kvTable.MultiDelete([]int64{2000, 2000}))
+
+	deleted = kvTable.MultiDelete(append(keys[:50:50], 1000))
+	for i := 0; i < 50; i++ {
+		assert.True(t, deleted[i])
+	}
+	assert.False(t, deleted[50])
+	assert.Equal(t, 50, kvTable.Stats().ObjectsNum)
+	uObjects = kvTable.MultiGetWithReadAcquire(keys)
+	for i, uObject := range uObjects {
+		assert.Equal(t, i >= 50, uObject != 0)
+		if uObject != 0 {
+			HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+		}
+	}
+}
+
+func benchmarkHKVTableBatch(b *testing.B, isBatch bool) {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithInt64
+		keysNum       = 1 << 16
+		batchSize     = 256
+		keys          = make([]int64, batchSize)
+		err           error
+	)
+
+	util.AssertErrIsNil(offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithInt64("bench",
+		int(unsafe.Sizeof(HKVTableObjectWithInt64{})), int32(keysNum), 32, nil, nil)
+	util.AssertErrIsNil(err)
+	for k := 0; k < keysNum; k++ {
+		uObject, _, err := kvTable.MustGetObjectWithReadAcquire(int64(k))
+		util.AssertErrIsNil(err)
+		HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n += batchSize {
+		for i := range keys {
+			keys[i] = int64((n + i*97) % keysNum)
+		}
+		if isBatch {
+			for _, uObject := range kvTable.MultiGetWithReadAcquire(keys) {
+				HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+			}
+			continue
+		}
+		for _, k := range keys {
+			uObject := kvTable.TryGetObjectWithReadAcquire(k)
+			HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+		}
+	}
+}
+
+func BenchmarkHKVTableGetOneByOne(b *testing.B) {
+	benchmarkHKVTableBatch(b, false)
+}
+
+func BenchmarkHKVTableMultiGetWithReadAcquire(b *testing.B) {
+	benchmarkHKVTableBatch(b, true)
+}
+
+func TestHKVTableOffheapIndexKeyType(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	_, err = offheapDriver.CreateHKVTableWithString("string",
+		int(unsafe.Sizeof(HKVTableObjectWithString{})), 16, 4, nil, nil,
+		HKVTableOptions{Index: HKVTableIndexOffheap})
+	assert.Equal(t, ErrUnknownKeyType, err)
+	assert.Nil(t, offheapDriver.GetTable("string"))
+	_, err = offheapDriver.CreateHKVTableWithBytes12("bytes12",
+		int(unsafe.Sizeof(HKVTableObjectWithBytes12{})), 16, 4, nil, nil,
+		HKVTableOptions{Index: HKVTableIndexOffheap})
+	assert.NoError(t, err)
+}
+
+func TestHKVTableReshard(t *testing.T) {
+	testHKVTableReshard(t, HKVTableOptions{})
+	testHKVTableReshard(t, HKVTableOptions{Index: HKVTableIndexOffheap})
+}
+
+func testHKVTableReshard(t *testing.T, options HKVTableOptions) {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithInt64
+		shareds       *hkvTableShareds[int64]
+		stop          int32
+		waitGroup     sync.WaitGroup
+		err           error
+	)
+
+	mustGet := func(k int64) {
+		uObject, _, err := kvTable.MustGetObjectWithReadAcquire(k)
+		assert.NoError(t, err)
+		HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+	}
+	// checkObjects checks the objects are the keys of isKey once
+	checkObjects := func(isKey func(k int64) bool, keysNum int) {
+		var visited = make(map[int64]int)
+		kvTable.ForEach(func(k int64, uObject uintptr) bool {
+			visited[k]++
+			return true
+		})
+		for cursor := kvTable.Scan(0, 10, func(k int64, uObject uintptr) bool {
+			visited[k]++
+			return true
+		}); cursor != 0; {
+			cursor = kvTable.Scan(cursor, 10, func(k int64, uObject uintptr) bool {
+				visited[k]++
+				return true
+			})
+		}
+		for k, visitedNum := range visited {
+			assert.True(t, isKey(k))
+			assert.Equal(t, 2, visitedNum)
+		}
+		assert.Equal(t, keysNum, len(visited))
+		assert.Equal(t, keysNum, kvTable.Stats().ObjectsNum)
+	}
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithInt64("reshard",
+		int(unsafe.Sizeof(HKVTableObjectWithInt64{})), 4096, 2, nil, nil, options)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrSharedCountInvalid, kvTable.Reshard(0))
+	for k := int64(0); k < 1000; k++ {
+		mustGet(k)
+	}
+
+	// half migrated
+	shareds = kvTable.loadShareds()
+	shareds.next, err = kvTable.newShareds(7)
+	assert.NoError(t, err)
+	assert.NoError(t, kvTable.migrateShared(shareds, 0))
+	for k := int64(0); k < 1000; k += 2 {
+		kvTable.DeleteObject(k)
+	}
+	for k := int64(1000); k < 1100; k++ {
+		mustGet(k)
+	}
+	checkObjects(func(k int64) bool { return k%2 == 1 || k >= 1000 }, 600)
+	assert.Equal(t, uint32(2), kvTable.SharedCount())
+	uObjects := kvTable.MultiGetWithReadAcquire([]int64{1, 2, 3, 1050})
+	for i, uObject := range uObjects {
+		assert.Equal(t, i != 1, uObject != 0)
+		if uObject != 0 {
+			HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+		}
+	}
+	assert.Equal(t, []bool{true, false}, kvTable.MultiDelete([]int64{1099, 2}))
+	for _, result := range kvTable.MultiMustGetWithReadAcquire([]int64{1099, 1098}) {
+		assert.NoError(t, result.Err)
+		HKVTableObjectUPtrWithInt64(result.UObject).Ptr().ReadRelease()
+	}
+
+	// resumed then resharded again
+	assert.NoError(t, kvTable.Reshard(16))
+	assert.Equal(t, uint32(16), kvTable.SharedCount())
+	checkObjects(func(k int64) bool { return k%2 == 1 || k >= 1000 }, 600)
+
+	// objects are got and deleted while resharding
+	for i := 0; i < 4; i++ {
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			for n := int64(0); atomic.LoadInt32(&stop) == 0; n++ {
+				k := 2000 + (n*4+int64(i))%1000
+				mustGet(k)
+				if n%3 == 0 {
+					kvTable.DeleteObject(k)
+				}
+			}
+		}(i)
+	}
+	for _, sharedCount := range []uint32{3, 32, 5} {
+		assert.NoError(t, kvTable.Reshard(sharedCount))
+		assert.Equal(t, sharedCount, kvTable.SharedCount())
+	}
+	atomic.StoreInt32(&stop, 1)
+	waitGroup.Wait()
+
+	for k := int64(2000); k < 3000; k++ {
+		kvTable.DeleteObject(k)
+	}
+	checkObjects(func(k int64) bool { return k%2 == 1 || (k >= 1000 && k < 1100) }, 600)
+	for k := int64(1); k < 1000; k += 2 {
+		uObject := kvTable.TryGetObjectWithReadAcquire(k)
+		assert.NotEqual(t, uintptr(0), uObject)
+		HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+	}
+	assert.Equal(t, int32(600), kvTable.Stats().ActiveChunksNum)
+	assert.NoError(t, kvTable.Close())
+}
+
+func TestHKVTableVersion(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithInt64
+		restored      *HKVTableWithInt64
+		snapshot      bytes.Buffer
+		waitGroup     sync.WaitGroup
+		value         []byte
+		version       uint64
+		oldVersion    uint64
+		swapped       bool
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithInt64("version",
+		int(unsafe.Sizeof(HKVTableObjectWithInt64{})), 128, 4, nil, nil)
+	assert.NoError(t, err)
+
+	value, version = kvTable.GetWithVersion(1)
+	assert.Nil(t, value)
+	assert.Equal(t, uint64(0), version)
+	version, swapped, err = kvTable.CompareAndSwap(1, 7, []byte("a"))
+	assert.NoError(t, err)
+	assert.False(t, swapped)
+	assert.Equal(t, uint64(0), version)
+
+	// version 0 expects no object
+	oldVersion, swapped, err = kvTable.CompareAndSwap(1, 0, []byte("a"))
+	assert.NoError(t, err)
+	assert.True(t, swapped)
+	assert.NotEqual(t, uint64(0), oldVersion)
+	version, swapped, err = kvTable.CompareAndSwap(1, 0, []byte("b"))
+	assert.NoError(t, err)
+	assert.False(t, swapped)
+	assert.Equal(t, oldVersion, version)
+
+	value, version = kvTable.GetWithVersion(1)
+	assert.Equal(t, []byte("a"), value)
+	assert.Equal(t, oldVersion, version)
+	version, swapped, err = kvTable.CompareAndSwap(1, oldVersion, []byte("b"))
+	assert.NoError(t, err)
+	assert.True(t, swapped)
+	assert.True(t, version > oldVersion)
+	_, swapped, err = kvTable.CompareAndSwap(1, oldVersion, []byte("c"))
+	assert.NoError(t, err)
+	assert.False(t, swapped)
+	value, _ = kvTable.GetWithVersion(1)
+	assert.Equal(t, []byte("b"), value)
+
+	// a key alloced again does not reuse the versions of the deleted object
+	oldVersion = version
+	kvTable.DeleteObject(1)
+	_, swapped, err = kvTable.CompareAndSwap(1, oldVersion, []byte("c"))
+	assert.NoError(t, err)
+	assert.False(t, swapped)
+	version, swapped, err = kvTable.CompareAndSwap(1, 0, []byte("c"))
+	assert.NoError(t, err)
+	assert.True(t, swapped)
+	assert.True(t, version > oldVersion)
+
+	uObject := kvTable.TryGetObjectWithWriteAcquire(1)
+	assert.True(t, kvTable.IncreaseObjectVersion(uObject) > version)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().WriteRelease()
+
+	// optimistic increments of a counter
+	_, _, err = kvTable.CompareAndSwap(2, 0, make([]byte, 8))
+	assert.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for n := 0; n < 100; n++ {
+				for {
+					counter, version := kvTable.GetWithVersion(2)
+					binary.LittleEndian.PutUint64(counter, binary.LittleEndian.Uint64(counter)+1)
+					_, swapped, err := kvTable.CompareAndSwap(2, version, counter)
+					assert.NoError(t, err)
+					if swapped {
+						break
+					}
+				}
+			}
+		}()
+	}
+	waitGroup.Wait()
+	value, version = kvTable.GetWithVersion(2)
+	assert.Equal(t, uint64(400), binary.LittleEndian.Uint64(value))
+
+	// snapshots keep versions
+	assert.NoError(t, kvTable.Snapshot(&snapshot))
+	restored, err = offheapDriver.CreateHKVTableWithInt64("restored",
+		int(unsafe.Sizeof(HKVTableObjectWithInt64{})), 128, 4, nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, restored.Restore(bytes.NewReader(snapshot.Bytes())))
+	value, oldVersion = restored.GetWithVersion(2)
+	assert.Equal(t, uint64(400), binary.LittleEndian.Uint64(value))
+	assert.Equal(t, version, oldVersion)
+	assert.NoError(t, restored.SetValue(3, nil))
+	_, version = restored.GetWithVersion(3)
+	assert.True(t, version > oldVersion)
+}
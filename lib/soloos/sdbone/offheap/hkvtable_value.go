@@ -0,0 +1,95 @@
+package offheap
+
+import "unsafe"
+
+// ObjectValue returns the value of uObject, which must be acquired.
+// The view is valid until uObject is released.
+func (p *HKVTable[K]) ObjectValue(uObject uintptr) OBytes {
+	return HKVTableObjectUPtr[K](uObject).Ptr().value
+}
+
+// SetObjectValue replaces the value of uObject, which must be write acquired,
+// by a copy of value malloced in offheap memory, and increases its version.
+// The value takes its bytes from the MemoryBudget of the HKVTable if any, it
+// returns ErrAllocChunkOurOfLimit if no object can be evicted for them.
+func (p *HKVTable[K]) SetObjectValue(uObject uintptr, value []byte) error {
+	var (
+		v        = HKVTableObjectUPtr[K](uObject)
+		newValue OBytes
+		err      error
+	)
+
+	// reuses the block of the old value if value fits without wasting half of it
+	if len(value) <= v.Ptr().value.Cap && len(value) >= v.Ptr().value.Cap/2 {
+		newValue = v.Ptr().value
+	} else if len(value) > 0 {
+		newValue.Data, err = p.mallocOffheapDriver().Malloc(len(value))
+		if err != nil {
+			return err
+		}
+		newValue.Cap = p.mallocOffheapDriver().MallocSize(newValue.Data)
+		if p.memoryBudget != nil &&
+			p.chunkPool.reserveBudgetBytes(int64(newValue.Cap)) == false {
+			p.mallocOffheapDriver().Free(newValue.Data)
+			return ErrAllocChunkOurOfLimit
+		}
+	}
+	newValue.Len = len(value)
+	if newValue.Len > 0 {
+		copy((*[1 << 40]byte)(unsafe.Pointer(newValue.Data))[:newValue.Len:newValue.Len], value)
+	}
+
+	if newValue.Data != v.Ptr().value.Data {
+		p.freeObjectValue(v)
+	}
+	v.Ptr().value = newValue
+	v.Ptr().Version = p.nextVersion()
+
+	return nil
+}
+
+func (p *HKVTable[K]) freeObjectValue(v HKVTableObjectUPtr[K]) {
+	if v.Ptr().value.Data != 0 {
+		p.mallocOffheapDriver().Free(v.Ptr().value.Data)
+		if p.memoryBudget != nil {
+			p.chunkPool.releaseBudgetBytes(int64(v.Ptr().value.Cap))
+		}
+	}
+	v.Ptr().value = OBytes{}
+}
+
+// GetValueWithReadAcquire returns the object of objKey read acquired, and its
+// value valid until the object is released, or 0 if there is no object of objKey
+func (p *HKVTable[K]) GetValueWithReadAcquire(objKey K) (uintptr, OBytes) {
+	var uObject = p.TryGetObjectWithReadAcquire(objKey)
+	if uObject == 0 {
+		return 0, OBytes{}
+	}
+	return uObject, p.ObjectValue(uObject)
+}
+
+// SetValue replaces the value of the object of objKey, alloc if needed,
+// readers see the old value or the new one but never a mix of both
+func (p *HKVTable[K]) SetValue(objKey K, value []byte) error {
+	var (
+		uObject uintptr
+		err     error
+	)
+
+	uObject, _, err = p.MustGetObjectWithWriteAcquire(objKey)
+	if err != nil {
+		return err
+	}
+	err = p.SetObjectValue(uObject, value)
+	HKVTableObjectUPtr[K](uObject).Ptr().WriteRelease()
+
+	return err
+}
+
+// OBytesToBytes returns the bytes viewed by bytes, without copy
+func OBytesToBytes(bytes OBytes) []byte {
+	if bytes.Len == 0 {
+		return nil
+	}
+	return (*[1 << 40]byte)(unsafe.Pointer(bytes.Data))[:bytes.Len:bytes.Len]
+}
@@ -0,0 +1,100 @@
+package offheap
+
+import "sync/atomic"
+
+func (p *HKVTable[K]) nextVersion() uint64 {
+	return atomic.AddUint64(&p.maxVersion, 1)
+}
+
+// ensureVersion makes the next versions greater than version
+func (p *HKVTable[K]) ensureVersion(version uint64) {
+	for {
+		maxVersion := atomic.LoadUint64(&p.maxVersion)
+		if maxVersion >= version ||
+			atomic.CompareAndSwapUint64(&p.maxVersion, maxVersion, version) {
+			return
+		}
+	}
+}
+
+// ObjectVersion returns the version of uObject, which must be acquired
+func (p *HKVTable[K]) ObjectVersion(uObject uintptr) uint64 {
+	return HKVTableObjectUPtr[K](uObject).Ptr().Version
+}
+
+// IncreaseObjectVersion increases the version of uObject, which must be write
+// acquired, for writers changing the bytes of the object in place. It returns
+// the new version.
+func (p *HKVTable[K]) IncreaseObjectVersion(uObject uintptr) uint64 {
+	HKVTableObjectUPtr[K](uObject).Ptr().Version = p.nextVersion()
+	return HKVTableObjectUPtr[K](uObject).Ptr().Version
+}
+
+// GetWithVersion returns a copy of the value of the object of objKey and its
+// version, or a version 0 if there is no object of objKey. The object is not
+// kept acquired, its value is changed by CompareAndSwap only if its version is the same.
+func (p *HKVTable[K]) GetWithVersion(objKey K) ([]byte, uint64) {
+	var (
+		uObject uintptr
+		value   []byte
+		version uint64
+	)
+
+	uObject = p.TryGetObjectWithReadAcquire(objKey)
+	if uObject == 0 {
+		return nil, 0
+	}
+	value = append([]byte(nil), OBytesToBytes(p.ObjectValue(uObject))...)
+	version = p.ObjectVersion(uObject)
+	HKVTableObjectUPtr[K](uObject).Ptr().ReadRelease()
+
+	return value, version
+}
+
+// CompareAndSwap replaces the value of the object of objKey by value if its
+// version is expectedVersion, an expectedVersion 0 expects no object of objKey
+// and allocs it. It returns the version of the object after CompareAndSwap, 0
+// if there is none, and whether value is set.
+// The error result is ErrMmap or ErrAllocChunkOurOfLimit if the object could not
+// be alloc, or the error of Malloc if value could not be, the object keeps its
+// value and version then, an object alloced by CompareAndSwap is deleted.
+func (p *HKVTable[K]) CompareAndSwap(objKey K, expectedVersion uint64, value []byte) (uint64, bool, error) {
+	var (
+		uObject      uintptr
+		isMismatched bool
+		version      uint64
+		err          error
+	)
+
+	if expectedVersion == 0 {
+		uObject, isMismatched, err = p.MustGetObjectWithWriteAcquire(objKey)
+		if err != nil {
+			return 0, false, err
+		}
+	} else {
+		uObject = p.TryGetObjectWithWriteAcquire(objKey)
+		if uObject == 0 {
+			return 0, false, nil
+		}
+		isMismatched = p.ObjectVersion(uObject) != expectedVersion
+	}
+
+	if isMismatched {
+		version = p.ObjectVersion(uObject)
+		HKVTableObjectUPtr[K](uObject).Ptr().WriteRelease()
+		return version, false, nil
+	}
+
+	err = p.SetObjectValue(uObject, value)
+	if err != nil && expectedVersion == 0 {
+		p.deleteWriteAcquiredObject(objKey, p.hasher(objKey), HKVTableObjectUPtr[K](uObject))
+		return 0, false, err
+	}
+	version = p.ObjectVersion(uObject)
+	HKVTableObjectUPtr[K](uObject).Ptr().WriteRelease()
+	if err != nil {
+		return version, false, err
+	}
+
+	return version, true, nil
+}
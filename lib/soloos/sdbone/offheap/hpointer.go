@@ -24,6 +24,8 @@ type HSharedPointer struct {
 	accessRWMutex sync.RWMutex
 	accessor      int32
 	status        int32
+	// writesNum is increased by every write acquire, under accessRWMutex
+	writesNum uint32
 }
 
 func (p *HSharedPointer) SetReleasable() {
@@ -66,9 +68,30 @@ func (p *HSharedPointer) ReadRelease() {
 func (p *HSharedPointer) WriteAcquire() {
 	atomic.AddInt32(&p.accessor, 1)
 	p.accessRWMutex.Lock()
+	p.writesNum++
 }
 
 func (p *HSharedPointer) WriteRelease() {
 	p.accessRWMutex.Unlock()
 	atomic.AddInt32(&p.accessor, -1)
 }
+
+// UpgradeAcquire turns a read acquire into a write acquire. It is not atomic,
+// other writers may acquire in between, but the accessor is kept counted.
+// It returns false if another writer acquired in between, what was read under
+// the read acquire may be stale then.
+func (p *HSharedPointer) UpgradeAcquire() bool {
+	var writesNum = p.writesNum
+	atomic.AddInt32(&p.accessor, 1)
+	p.ReadRelease()
+	p.accessRWMutex.Lock()
+	p.writesNum++
+	return p.writesNum == writesNum+1
+}
+
+// DowngradeAcquire turns a write acquire into a read acquire. It is not atomic,
+// other writers may acquire in between, but the accessor is kept counted.
+func (p *HSharedPointer) DowngradeAcquire() {
+	p.accessRWMutex.Unlock()
+	p.accessRWMutex.RLock()
+}
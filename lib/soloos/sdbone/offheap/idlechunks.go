@@ -0,0 +1,126 @@
+package offheap
+
+import (
+	"sync"
+	"time"
+)
+
+// idleChunks holds the free chunks of a pool which are not alloced for a
+// while and whose pages are advised, see PoolOptions.FreeChunkIdleDuration
+type idleChunks struct {
+	mutex    sync.Mutex
+	chunks   []uintptr
+	stopChan chan struct{}
+}
+
+// start invokes adviseFunc every idleDuration until stop
+func (p *idleChunks) start(idleDuration time.Duration, adviseFunc func() int) {
+	var stopChan = make(chan struct{})
+	p.stopChan = stopChan
+	go func() {
+		ticker := time.NewTicker(idleDuration)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				adviseFunc()
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+}
+
+func (p *idleChunks) stop() {
+	if p.stopChan != nil {
+		close(p.stopChan)
+		p.stopChan = nil
+	}
+	p.mutex.Lock()
+	p.chunks = nil
+	p.mutex.Unlock()
+}
+
+// advise invokes adviseChunkFunc on freeChunks, the chunks which stayed free
+// since the last advise, then makes them idle. It returns the number of bytes advised.
+func (p *idleChunks) advise(freeChunks []uintptr, adviseChunkFunc func(uChunk uintptr) int) int {
+	var advisedSize int
+
+	for _, uChunk := range freeChunks {
+		advisedSize += adviseChunkFunc(uChunk)
+	}
+
+	p.mutex.Lock()
+	p.chunks = append(p.chunks, freeChunks...)
+	p.mutex.Unlock()
+
+	return advisedSize
+}
+
+// pop returns an idle chunk, or 0
+func (p *idleChunks) pop() uintptr {
+	var ret uintptr
+
+	p.mutex.Lock()
+	if last := len(p.chunks) - 1; last >= 0 {
+		ret = p.chunks[last]
+		p.chunks = p.chunks[:last]
+	}
+	p.mutex.Unlock()
+
+	return ret
+}
+
+func (p *idleChunks) len() int {
+	p.mutex.Lock()
+	ret := len(p.chunks)
+	p.mutex.Unlock()
+	return ret
+}
+
+// takeAll removes every idle chunk and returns them
+func (p *idleChunks) takeAll() []uintptr {
+	p.mutex.Lock()
+	ret := p.chunks
+	p.chunks = nil
+	p.mutex.Unlock()
+	return ret
+}
+
+func (p *idleChunks) putAll(chunks []uintptr) {
+	p.mutex.Lock()
+	p.chunks = append(p.chunks, chunks...)
+	p.mutex.Unlock()
+}
+
+// splitChunks splits freeChunks into the chunks not found in subChunks and
+// the subChunks found in freeChunks, such as the idle chunks of a pool
+func splitChunks(freeChunks []uintptr, subChunks []uintptr) ([]uintptr, []uintptr) {
+	var (
+		freeChunksIndex = make(map[uintptr]bool, len(freeChunks))
+		subChunksIndex  = make(map[uintptr]bool, len(subChunks))
+		keptFreeChunks  []uintptr
+		keptSubChunks   []uintptr
+	)
+
+	if len(subChunks) == 0 {
+		return freeChunks, nil
+	}
+
+	for _, uChunk := range freeChunks {
+		freeChunksIndex[uChunk] = true
+	}
+	for _, uChunk := range subChunks {
+		subChunksIndex[uChunk] = true
+		if freeChunksIndex[uChunk] {
+			keptSubChunks = append(keptSubChunks, uChunk)
+		}
+	}
+	for _, uChunk := range freeChunks {
+		if subChunksIndex[uChunk] == false {
+			keptFreeChunks = append(keptFreeChunks, uChunk)
+		}
+	}
+
+	return keptFreeChunks, keptSubChunks
+}
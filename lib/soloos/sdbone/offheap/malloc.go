@@ -1 +1,230 @@
 package offheap
+
+import (
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	MallocHeaderSize   = int(unsafe.Sizeof(mallocHeader{}))
+	MallocSizeClassMax = 32 << 10
+
+	mallocHeaderMagic      = uint32(0x434c4d4f) // "OMLC"
+	mallocLargeObjectClass = int32(-1)
+	mallocSizeClassAlign   = 16
+)
+
+type mallocHeader struct {
+	magic     uint32
+	sizeClass int32
+	size      uintptr // size of the block, header included
+}
+
+type mallocHeaderUintptr uintptr
+
+func (u mallocHeaderUintptr) Ptr() *mallocHeader { return (*mallocHeader)(unsafe.Pointer(u)) }
+
+type mallocSizeClassPool struct {
+	once sync.Once
+	err  error
+	pool RawChunkPool
+}
+
+// offheapMalloc serves Malloc with a RawChunkPool per size class, blocks
+// larger than MallocSizeClassMax are mmaped one by one.
+// Each block starts with a mallocHeader, Malloc returns the address right after it.
+//
+// size classes are multiples of mallocSizeClassAlign up to 128, then 4 size
+// classes between two powers of 2, that is
+// 16, 32, ... 128, 160, 192, 224, 256, 320, 384, 448, 512, ... MallocSizeClassMax
+type offheapMalloc struct {
+	sizeClasses      []int
+	sizeClassIndexes [MallocSizeClassMax/mallocSizeClassAlign + 1]int32
+	pools            []mallocSizeClassPool
+}
+
+func (p *offheapMalloc) Init() {
+	var (
+		size, step int
+		k          int
+	)
+
+	p.sizeClasses = nil
+	for size = mallocSizeClassAlign; size <= 128; size += mallocSizeClassAlign {
+		p.sizeClasses = append(p.sizeClasses, size)
+	}
+	for size, step = 128, 32; size < MallocSizeClassMax; step *= 2 {
+		for k = 0; k < 4; k++ {
+			size += step
+			p.sizeClasses = append(p.sizeClasses, size)
+		}
+	}
+
+	k = 0
+	for i := range p.sizeClassIndexes {
+		for p.sizeClasses[k] < i*mallocSizeClassAlign {
+			k++
+		}
+		p.sizeClassIndexes[i] = int32(k)
+	}
+
+	p.pools = make([]mallocSizeClassPool, len(p.sizeClasses))
+}
+
+func (p *offheapMalloc) sizeClassPool(driver *OffheapDriver, sizeClass int32) (*RawChunkPool, error) {
+	var sizeClassPool = &p.pools[sizeClass]
+	sizeClassPool.once.Do(func() {
+		sizeClassPool.err = sizeClassPool.pool.Init(driver.AllocTableID(),
+			p.sizeClasses[sizeClass], -1, nil, nil)
+		if sizeClassPool.err == nil {
+			driver.SetRawChunkPool(&sizeClassPool.pool)
+		}
+	})
+	return &sizeClassPool.pool, sizeClassPool.err
+}
+
+// Malloc returns size bytes of offheap memory, aligned to 16 bytes, which
+// must be given back by Free. It returns ErrMallocInvalidSize if size is negative.
+func (p *OffheapDriver) Malloc(size int) (uintptr, error) {
+	var (
+		blockSize = size + MallocHeaderSize
+		sizeClass int32
+		pool      *RawChunkPool
+		uHeader   mallocHeaderUintptr
+		uBlock    uintptr
+		err       error
+	)
+
+	if size < 0 {
+		return 0, ErrMallocInvalidSize
+	}
+
+	if blockSize > MallocSizeClassMax {
+		return p.mallocLargeObject(blockSize)
+	}
+
+	sizeClass = p.malloc.sizeClassIndexes[(blockSize+mallocSizeClassAlign-1)/mallocSizeClassAlign]
+	pool, err = p.malloc.sizeClassPool(p, sizeClass)
+	if err != nil {
+		return 0, err
+	}
+
+	uBlock, err = pool.TryAllocRawChunk()
+	if err != nil {
+		return 0, err
+	}
+
+	uHeader = mallocHeaderUintptr(uBlock)
+	uHeader.Ptr().magic = mallocHeaderMagic
+	uHeader.Ptr().sizeClass = sizeClass
+	uHeader.Ptr().size = uintptr(p.malloc.sizeClasses[sizeClass])
+
+	return uBlock + uintptr(MallocHeaderSize), nil
+}
+
+func (p *OffheapDriver) mallocLargeObject(blockSize int) (uintptr, error) {
+	var (
+		mmapBytes mmapbytes
+		uHeader   mallocHeaderUintptr
+		err       error
+	)
+
+	mmapBytes, err = AllocMmapBytes(blockSize)
+	if err != nil {
+		return 0, err
+	}
+
+	uHeader = mallocHeaderUintptr(mmapBytes.addrBase())
+	uHeader.Ptr().magic = mallocHeaderMagic
+	uHeader.Ptr().sizeClass = mallocLargeObjectClass
+	uHeader.Ptr().size = uintptr(blockSize)
+
+	return uintptr(uHeader) + uintptr(MallocHeaderSize), nil
+}
+
+func (p *OffheapDriver) mallocHeader(ptr uintptr) mallocHeaderUintptr {
+	var uHeader = mallocHeaderUintptr(ptr - uintptr(MallocHeaderSize))
+	if uHeader.Ptr().magic != mallocHeaderMagic {
+		panic(ErrMallocInvalidPointer)
+	}
+	return uHeader
+}
+
+// MallocSize returns the number of bytes usable at ptr returned by Malloc,
+// it may be more than the size asked.
+func (p *OffheapDriver) MallocSize(ptr uintptr) int {
+	return int(p.mallocHeader(ptr).Ptr().size) - MallocHeaderSize
+}
+
+// Free gives back ptr returned by Malloc or Realloc, Free(0) does nothing.
+func (p *OffheapDriver) Free(ptr uintptr) {
+	var (
+		uHeader   mallocHeaderUintptr
+		sizeClass int32
+		blockSize uintptr
+	)
+
+	if ptr == 0 {
+		return
+	}
+
+	uHeader = p.mallocHeader(ptr)
+	sizeClass = uHeader.Ptr().sizeClass
+	blockSize = uHeader.Ptr().size
+	uHeader.Ptr().magic = 0
+
+	if sizeClass == mallocLargeObjectClass {
+		syscall.Syscall(syscall.SYS_MUNMAP, uintptr(uHeader), blockSize, 0)
+		return
+	}
+
+	p.malloc.pools[sizeClass].pool.ReleaseRawChunk(uintptr(uHeader))
+}
+
+// Realloc resizes ptr returned by Malloc to size bytes, keeping its content,
+// and returns the new address. Realloc(0, size) is Malloc(size).
+// ptr is still valid if Realloc fails.
+func (p *OffheapDriver) Realloc(ptr uintptr, size int) (uintptr, error) {
+	var (
+		oldSize int
+		newPtr  uintptr
+		err     error
+	)
+
+	if size < 0 {
+		return 0, ErrMallocInvalidSize
+	}
+
+	if ptr == 0 {
+		return p.Malloc(size)
+	}
+
+	oldSize = p.MallocSize(ptr)
+	if size <= oldSize {
+		return ptr, nil
+	}
+
+	newPtr, err = p.Malloc(size)
+	if err != nil {
+		return 0, err
+	}
+
+	copy((*[1 << 40]byte)(unsafe.Pointer(newPtr))[:oldSize:oldSize],
+		(*[1 << 40]byte)(unsafe.Pointer(ptr))[:oldSize:oldSize])
+	p.Free(ptr)
+
+	return newPtr, nil
+}
+
+func Malloc(size int) (uintptr, error) {
+	return DefaultOffheapDriver.Malloc(size)
+}
+
+func Free(ptr uintptr) {
+	DefaultOffheapDriver.Free(ptr)
+}
+
+func Realloc(ptr uintptr, size int) (uintptr, error) {
+	return DefaultOffheapDriver.Realloc(ptr, size)
+}
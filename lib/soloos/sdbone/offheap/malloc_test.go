@@ -0,0 +1,66 @@
+package offheap
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOffheapDriverMalloc(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		ptrs          []uintptr
+		sizes         = []int{1, 15, 16, 17, 100, 1000, 4000, MallocSizeClassMax - MallocHeaderSize, MallocSizeClassMax, 1 << 20}
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+
+	for _, size := range sizes {
+		ptr, err := offheapDriver.Malloc(size)
+		assert.NoError(t, err)
+		assert.Equal(t, uintptr(0), ptr%16)
+		assert.True(t, offheapDriver.MallocSize(ptr) >= size)
+		bytes := (*[1 << 40]byte)(unsafe.Pointer(ptr))[:size:size]
+		for i := range bytes {
+			bytes[i] = byte(size)
+		}
+		ptrs = append(ptrs, ptr)
+	}
+
+	for i, size := range sizes {
+		bytes := (*[1 << 40]byte)(unsafe.Pointer(ptrs[i]))[:size:size]
+		assert.Equal(t, byte(size), bytes[0])
+		assert.Equal(t, byte(size), bytes[size-1])
+		offheapDriver.Free(ptrs[i])
+	}
+
+	smallPtr, err := offheapDriver.Malloc(24)
+	assert.NoError(t, err)
+	*(*uint64)(unsafe.Pointer(smallPtr)) = 0x1234
+
+	ptr, err := offheapDriver.Realloc(smallPtr, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, smallPtr, ptr)
+
+	ptr, err = offheapDriver.Realloc(ptr, MallocSizeClassMax*2)
+	assert.NoError(t, err)
+	assert.NotEqual(t, smallPtr, ptr)
+	assert.Equal(t, uint64(0x1234), *(*uint64)(unsafe.Pointer(ptr)))
+	offheapDriver.Free(ptr)
+
+	// smallPtr is freed by Realloc
+	assert.Panics(t, func() { offheapDriver.Free(smallPtr) })
+
+	for _, size := range []int{-1, -100} {
+		ptr, err = offheapDriver.Malloc(size)
+		assert.Equal(t, ErrMallocInvalidSize, err)
+		assert.Equal(t, uintptr(0), ptr)
+	}
+	smallPtr, err = offheapDriver.Malloc(24)
+	assert.NoError(t, err)
+	ptr, err = offheapDriver.Realloc(smallPtr, -1)
+	assert.Equal(t, ErrMallocInvalidSize, err)
+	assert.Equal(t, uintptr(0), ptr)
+	offheapDriver.Free(smallPtr)
+}
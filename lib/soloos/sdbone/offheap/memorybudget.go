@@ -0,0 +1,114 @@
+package offheap
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// MemoryBudget is a limit of bytes shared by RawChunkPools, such as the pools of
+// several HKVTables, set by PoolOptions.MemoryBudget or HKVTableOptions.MemoryBudget.
+// Every raw chunk alloced by a pool of the budget takes its size from the budget,
+// like the values of the HKVTables of the budget.
+// While the budget is exceeded a pool invokes the releaseRawChunkFunc of the
+// pool of the budget using the most bytes, which may be itself, then waits for
+// a raw chunk of the budget to be released if none is.
+type MemoryBudget struct {
+	limit      int64
+	bytesInUse int64
+	// releasesNum counts the releases of bytes
+	releasesNum int64
+
+	poolsRWMutex sync.RWMutex
+	pools        []*RawChunkPool
+
+	waiters chunkWaiters
+}
+
+func (p *MemoryBudget) Init(limit int64) {
+	p.limit = limit
+	p.bytesInUse = 0
+}
+
+// Limit returns the number of bytes of the budget
+func (p *MemoryBudget) Limit() int64 {
+	return p.limit
+}
+
+// BytesInUse returns the number of bytes of the raw chunks alloced from the
+// pools of the budget and of the values of its HKVTables
+func (p *MemoryBudget) BytesInUse() int64 {
+	return atomic.LoadInt64(&p.bytesInUse)
+}
+
+func (p *MemoryBudget) reserve(size int64) bool {
+	for {
+		bytesInUse := atomic.LoadInt64(&p.bytesInUse)
+		if bytesInUse+size > p.limit {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&p.bytesInUse, bytesInUse, bytesInUse+size) {
+			return true
+		}
+	}
+}
+
+func (p *MemoryBudget) release(size int64) {
+	atomic.AddInt64(&p.bytesInUse, -size)
+	atomic.AddInt64(&p.releasesNum, 1)
+	p.waiters.broadcast()
+}
+
+func (p *MemoryBudget) releasedNum() int64 {
+	return atomic.LoadInt64(&p.releasesNum)
+}
+
+func (p *MemoryBudget) addPool(pool *RawChunkPool) {
+	p.poolsRWMutex.Lock()
+	p.pools = append(p.pools, pool)
+	p.poolsRWMutex.Unlock()
+}
+
+// deletePool waits for the releaseRawChunkFunc of pool invoked by
+// releaseLargestPool to return
+func (p *MemoryBudget) deletePool(pool *RawChunkPool) {
+	p.poolsRWMutex.Lock()
+	for i := range p.pools {
+		if p.pools[i] == pool {
+			p.pools = append(p.pools[:i], p.pools[i+1:]...)
+			break
+		}
+	}
+	p.poolsRWMutex.Unlock()
+	pool.budgetReleases.Wait()
+}
+
+// releaseLargestPool invokes the releaseRawChunkFunc of the pool of the budget
+// using the most bytes, out of poolsRWMutex as it may release the raw chunks
+// of other pools of the budget
+func (p *MemoryBudget) releaseLargestPool() {
+	var (
+		largestPool       *RawChunkPool
+		largestBytesInUse int64
+		bytesInUse        int64
+	)
+
+	p.poolsRWMutex.RLock()
+	for _, pool := range p.pools {
+		if pool.releaseRawChunkFunc == nil {
+			continue
+		}
+		bytesInUse = pool.budgetBytesInUse()
+		if bytesInUse > largestBytesInUse {
+			largestPool, largestBytesInUse = pool, bytesInUse
+		}
+	}
+	if largestPool != nil {
+		largestPool.budgetReleases.Add(1)
+	}
+	p.poolsRWMutex.RUnlock()
+
+	if largestPool != nil {
+		largestPool.invokeReleaseRawChunk()
+		largestPool.budgetReleases.Done()
+	}
+}
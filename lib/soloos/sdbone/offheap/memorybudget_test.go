@@ -0,0 +1,184 @@
+package offheap
+
+import (
+	"context"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBudgetRawChunkPool(t *testing.T) {
+	var (
+		budget     MemoryBudget
+		pool0      RawChunkPool
+		pool1      RawChunkPool
+		uRawChunk0 uintptr
+		err        error
+	)
+
+	budget.Init(3 * 1024)
+	assert.NoError(t, pool0.Init(-1, 1024, -1, nil, nil, PoolOptions{MemoryBudget: &budget}))
+	assert.NoError(t, pool1.Init(-1, 1024, 2, nil, nil, PoolOptions{MemoryBudget: &budget}))
+
+	uRawChunk0, err = pool0.TryAllocRawChunk()
+	assert.NoError(t, err)
+	_, err = pool0.TryAllocRawChunk()
+	assert.NoError(t, err)
+	_, err = pool1.TryAllocRawChunk()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3*1024), budget.BytesInUse())
+	assert.Equal(t, int64(2*1024), pool0.Stats().BytesInUse)
+
+	_, err = pool1.TryAllocRawChunk()
+	assert.Equal(t, ErrAllocChunkOurOfLimit, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	_, err = pool1.AllocRawChunkCtx(ctx)
+	cancel()
+	assert.Equal(t, ErrAllocChunkOurOfLimit, err)
+
+	// a raw chunk released by another pool of the budget wakes up waiters
+	allocedChan := make(chan uintptr)
+	go func() {
+		allocedChan <- pool1.AllocRawChunk()
+	}()
+	for pool1.Stats().WaitersNum == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	pool0.ReleaseRawChunk(uRawChunk0)
+	assert.NotEqual(t, uintptr(0), <-allocedChan)
+	assert.Equal(t, int64(3*1024), budget.BytesInUse())
+
+	// rawChunksLimit is still enforced
+	assert.NoError(t, pool0.Close())
+	assert.Equal(t, int64(2*1024), budget.BytesInUse())
+	_, err = pool1.TryAllocRawChunk()
+	assert.Equal(t, ErrAllocChunkOurOfLimit, err)
+	assert.NoError(t, pool1.Close())
+	assert.Equal(t, int64(0), budget.BytesInUse())
+}
+
+func TestMemoryBudgetReleaseAddingPool(t *testing.T) {
+	var (
+		budget    MemoryBudget
+		pool0     RawChunkPool
+		pool1     RawChunkPool
+		uRawChunk uintptr
+		err       error
+	)
+
+	// the release func is invoked out of the lock of the pools of the budget
+	budget.Init(1024)
+	assert.NoError(t, pool0.Init(-1, 1024, -1, nil, func() {
+		assert.NoError(t, pool1.Init(-1, 1024, -1, nil, nil, PoolOptions{MemoryBudget: &budget}))
+		pool0.ReleaseRawChunk(uRawChunk)
+	}, PoolOptions{MemoryBudget: &budget}))
+
+	uRawChunk, err = pool0.TryAllocRawChunk()
+	assert.NoError(t, err)
+	_, err = pool0.TryAllocRawChunk()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1024), budget.BytesInUse())
+
+	assert.NoError(t, pool1.Close())
+	assert.NoError(t, pool0.Close())
+	assert.Equal(t, int64(0), budget.BytesInUse())
+}
+
+func TestMemoryBudgetHKVTable(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		budget        MemoryBudget
+		objectSize    = int(unsafe.Sizeof(HKVTableObjectWithInt64{}))
+		kvTable0      *HKVTableWithInt64
+		kvTable1      *HKVTableWithInt64
+		err           error
+	)
+
+	budget.Init(int64(100 * objectSize))
+	assert.NoError(t, offheapDriver.Init())
+	kvTable0, err = offheapDriver.CreateHKVTableWithInt64("budget0", objectSize, -1, 4, nil, nil,
+		HKVTableOptions{MemoryBudget: &budget})
+	assert.NoError(t, err)
+	kvTable1, err = offheapDriver.CreateHKVTableWithInt64("budget1", objectSize, -1, 4, nil, nil,
+		HKVTableOptions{MemoryBudget: &budget})
+	assert.NoError(t, err)
+
+	mustGet := func(kvTable *HKVTableWithInt64, k int64) {
+		uObject, _, err := kvTable.MustGetObjectWithReadAcquire(k)
+		assert.NoError(t, err)
+		HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+	}
+
+	// a table evicts its own objects once the budget is exceeded
+	for k := int64(0); k < 150; k++ {
+		mustGet(kvTable0, k)
+	}
+	assert.Equal(t, 100, kvTable0.Stats().ObjectsNum)
+	assert.Equal(t, int64(100*objectSize), kvTable0.Stats().BytesInUse)
+	assert.Equal(t, int64(100*objectSize), budget.BytesInUse())
+
+	// the table using the most bytes of the budget is evicted
+	for k := int64(0); k < 40; k++ {
+		mustGet(kvTable1, k)
+	}
+	assert.Equal(t, 60, kvTable0.Stats().ObjectsNum)
+	assert.Equal(t, 40, kvTable1.Stats().ObjectsNum)
+	assert.Equal(t, int64(100*objectSize), budget.BytesInUse())
+	for k := int64(40); k < 100; k++ {
+		mustGet(kvTable1, k)
+	}
+	assert.InDelta(t, 50, kvTable0.Stats().ObjectsNum, 1)
+	assert.InDelta(t, 50, kvTable1.Stats().ObjectsNum, 1)
+
+	kvTable1.DeleteObject(99)
+	assert.Equal(t, int64(99*objectSize), budget.BytesInUse())
+	assert.NoError(t, kvTable0.Close())
+	assert.NoError(t, kvTable1.Close())
+	assert.Equal(t, int64(0), budget.BytesInUse())
+}
+
+func TestMemoryBudgetHKVTableValue(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		budget        MemoryBudget
+		objectSize    = int(unsafe.Sizeof(HKVTableObjectWithInt64{}))
+		kvTable       *HKVTableWithInt64
+		value         = make([]byte, 1024)
+		err           error
+	)
+
+	budget.Init(int64(10 * (objectSize + len(value))))
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithInt64("budget", objectSize, -1, 4, nil, nil,
+		HKVTableOptions{MemoryBudget: &budget})
+	assert.NoError(t, err)
+
+	// values take bytes of the budget, objects are evicted for them
+	for k := int64(0); k < 20; k++ {
+		assert.NoError(t, kvTable.SetValue(k, value))
+		assert.True(t, budget.BytesInUse() <= budget.Limit())
+	}
+	assert.True(t, kvTable.Stats().ObjectsNum < 20)
+	uObject, objectValue := kvTable.GetValueWithReadAcquire(19)
+	assert.NotEqual(t, uintptr(0), uObject)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+	assert.Equal(t, int64(kvTable.Stats().ObjectsNum*(objectSize+objectValue.Cap)), budget.BytesInUse())
+
+	// a value larger than the budget evicts every object then fails
+	assert.Equal(t, ErrAllocChunkOurOfLimit, kvTable.SetValue(0, make([]byte, 20*len(value))))
+
+	// an object alloced by a CompareAndSwap whose value fails is deleted
+	_, swapped, err := kvTable.CompareAndSwap(100, 0, make([]byte, 20*len(value)))
+	assert.Equal(t, ErrAllocChunkOurOfLimit, err)
+	assert.False(t, swapped)
+	assert.Equal(t, uintptr(0), kvTable.TryGetObjectWithReadAcquire(100))
+
+	for k := int64(0); k < 20; k++ {
+		kvTable.DeleteObject(k)
+	}
+	assert.Equal(t, int64(0), budget.BytesInUse())
+	assert.NoError(t, kvTable.Close())
+	assert.Equal(t, int64(0), budget.BytesInUse())
+}
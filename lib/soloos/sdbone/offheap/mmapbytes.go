@@ -1,6 +1,9 @@
 package offheap
 
 import (
+	"os"
+	"sort"
+	"sync/atomic"
 	"syscall"
 	"unsafe"
 )
@@ -8,6 +11,68 @@ import (
 type mmapbytes struct {
 	addrStart uintptr
 	addrEnd   uintptr
+	bytes     []byte
+}
+
+const (
+	HugePageSize = 2 << 20
+
+	madvHugePage = 14 // MADV_HUGEPAGE
+)
+
+// AllocMmapBytesWithHugePage is AllocMmapBytes asking for huge pages,
+// hugePage is one of MmapHugePageNone, MmapHugePageTransparent and MmapHugePageHugeTLB,
+// size should be a multiple of HugePageSize
+func AllocMmapBytesWithHugePage(size int, hugePage int) (mmapbytes, error) {
+	var (
+		ret   mmapbytes
+		bytes []byte
+		err   error
+	)
+
+	if hugePage == MmapHugePageHugeTLB {
+		prot := syscall.PROT_READ | syscall.PROT_WRITE
+		flags := syscall.MAP_ANON | syscall.MAP_PRIVATE | syscall.MAP_HUGETLB
+		bytes, err = syscall.Mmap(-1, 0, size, prot, flags)
+		if err == nil {
+			ret.bytes = bytes
+			ret.addrStart = *((*uintptr)((unsafe.Pointer)(&bytes)))
+			ret.addrEnd = ret.addrStart + uintptr(size)
+			return ret, nil
+		}
+		// no huge page reserved, fall back to transparent huge pages
+		hugePage = MmapHugePageTransparent
+	}
+
+	ret, err = AllocMmapBytes(size)
+	if err != nil {
+		return ret, err
+	}
+
+	if hugePage == MmapHugePageTransparent {
+		// transparent huge pages may be disabled, it is fine to ignore the error
+		adviseMmapBytes(ret.addrBase(), ret.addrEnd, madvHugePage)
+	}
+
+	return ret, nil
+}
+
+// adviseMmapBytes madvises the pages inside [start, end), and returns the
+// number of bytes advised
+func adviseMmapBytes(start, end uintptr, advice int) int {
+	var pageSize = uintptr(os.Getpagesize())
+
+	start = (start + pageSize - 1) / pageSize * pageSize
+	end = end / pageSize * pageSize
+	if start >= end {
+		return 0
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_MADVISE, start, end-start, uintptr(advice))
+	if errno != 0 {
+		return 0
+	}
+	return int(end - start)
 }
 
 func AllocMmapBytes(size int) (mmapbytes, error) {
@@ -21,9 +86,164 @@ func AllocMmapBytes(size int) (mmapbytes, error) {
 
 	bytes, err = syscall.Mmap(-1, 0, size, prot, flags)
 	if err != nil {
-		panic(err)
+		return ret, ErrMmap
 	}
+	ret.bytes = bytes
 	ret.addrStart = *((*uintptr)((unsafe.Pointer)(&bytes)))
 	ret.addrEnd = ret.addrStart + uintptr(size)
 	return ret, err
 }
+
+// AllocMmapBytesWithGuardPage is AllocMmapBytes followed by a PROT_NONE page,
+// so that writing past the end of the mmapbytes faults at once. There is one
+// guard page for the whole mmapbytes, it does not sit between the items carved from it.
+// size is rounded up to a multiple of the page size
+func AllocMmapBytesWithGuardPage(size int) (mmapbytes, error) {
+	var (
+		ret      mmapbytes
+		pageSize = os.Getpagesize()
+		err      error
+	)
+
+	size = (size + pageSize - 1) / pageSize * pageSize
+	ret, err = AllocMmapBytes(size + pageSize)
+	if err != nil {
+		return ret, err
+	}
+
+	ret.addrEnd -= uintptr(pageSize)
+	_, _, errno := syscall.Syscall(syscall.SYS_MPROTECT, ret.addrEnd, uintptr(pageSize), syscall.PROT_NONE)
+	if errno != 0 {
+		FreeMmapBytes(&ret)
+		return ret, ErrMmap
+	}
+
+	return ret, nil
+}
+
+// AllocMmapBytesWithFile maps size bytes of file at offset with MAP_SHARED,
+// so that writes to the mmapbytes end up in file.
+func AllocMmapBytesWithFile(file *os.File, offset int64, size int) (mmapbytes, error) {
+	var (
+		ret   mmapbytes
+		bytes []byte
+		err   error
+	)
+	prot := syscall.PROT_READ | syscall.PROT_WRITE
+	flags := syscall.MAP_SHARED
+
+	bytes, err = syscall.Mmap(int(file.Fd()), offset, size, prot, flags)
+	if err != nil {
+		return ret, ErrMmap
+	}
+	ret.bytes = bytes
+	ret.addrStart = *((*uintptr)((unsafe.Pointer)(&bytes)))
+	ret.addrEnd = ret.addrStart + uintptr(size)
+	return ret, err
+}
+
+// SyncMmapBytes flushes a mmapbytes alloced by AllocMmapBytesWithFile to its file
+func SyncMmapBytes(mmapBytes *mmapbytes) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC,
+		mmapBytes.addrBase(), uintptr(len(mmapBytes.bytes)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func FreeMmapBytes(mmapBytes *mmapbytes) error {
+	var err error
+	err = syscall.Munmap(mmapBytes.bytes)
+	if err != nil {
+		return err
+	}
+	mmapBytes.bytes = nil
+	mmapBytes.addrStart = 0
+	mmapBytes.addrEnd = 0
+	return nil
+}
+
+func (p *mmapbytes) addrBase() uintptr {
+	return *((*uintptr)((unsafe.Pointer)(&p.bytes)))
+}
+
+// itemsNum returns how many items of itemSize have been carved from the mmapbytes
+func (p *mmapbytes) itemsNum(itemSize uintptr) int {
+	var end = atomic.LoadUintptr(&p.addrStart)
+	if end > p.addrEnd {
+		end = p.addrEnd
+	}
+	return int((end - p.addrBase()) / itemSize)
+}
+
+// sortMmapBytesList sorts mmapBytesList by address
+func sortMmapBytesList(mmapBytesList []*mmapbytes) {
+	sort.Slice(mmapBytesList, func(i, j int) bool {
+		return mmapBytesList[i].addrBase() < mmapBytesList[j].addrBase()
+	})
+}
+
+// searchMmapBytesList returns the index of the mmapbytes holding addr in
+// mmapBytesList sorted by sortMmapBytesList, or -1
+func searchMmapBytesList(mmapBytesList []*mmapbytes, addr uintptr) int {
+	k := sort.Search(len(mmapBytesList), func(k int) bool {
+		return mmapBytesList[k].addrEnd > addr
+	})
+	if k < len(mmapBytesList) && mmapBytesList[k].addrBase() <= addr {
+		return k
+	}
+	return -1
+}
+
+// shrinkMmapBytesList unmaps every mmapbytes in mmapBytesList, except
+// currentMmapBytes, whose carved items are all found in freeItems.
+// It returns the mmapbytes kept, the freeItems belonging to them and the
+// number of bytes released.
+func shrinkMmapBytesList(mmapBytesList []*mmapbytes, currentMmapBytes *mmapbytes,
+	itemSize uintptr, freeItems []uintptr) ([]*mmapbytes, []uintptr, int, error) {
+	var (
+		sortedMmapBytesList = make([]*mmapbytes, len(mmapBytesList))
+		freeItemsMmapBytes  = make([]*mmapbytes, len(freeItems))
+		freeItemsNum        = make(map[*mmapbytes]int, len(mmapBytesList))
+		released            = make(map[*mmapbytes]bool, len(mmapBytesList))
+		keptMmapBytesList   []*mmapbytes
+		keptFreeItems       []uintptr
+		releasedSize        int
+		err                 error
+	)
+
+	copy(sortedMmapBytesList, mmapBytesList)
+	sortMmapBytesList(sortedMmapBytesList)
+
+	for i, uItem := range freeItems {
+		k := searchMmapBytesList(sortedMmapBytesList, uItem)
+		if k >= 0 {
+			freeItemsMmapBytes[i] = sortedMmapBytesList[k]
+			freeItemsNum[sortedMmapBytesList[k]]++
+		}
+	}
+
+	for _, mmapBytes := range mmapBytesList {
+		if err == nil &&
+			mmapBytes != currentMmapBytes &&
+			freeItemsNum[mmapBytes] == mmapBytes.itemsNum(itemSize) {
+			size := len(mmapBytes.bytes)
+			err = FreeMmapBytes(mmapBytes)
+			if err == nil {
+				released[mmapBytes] = true
+				releasedSize += size
+				continue
+			}
+		}
+		keptMmapBytesList = append(keptMmapBytesList, mmapBytes)
+	}
+
+	for i, uItem := range freeItems {
+		if released[freeItemsMmapBytes[i]] == false {
+			keptFreeItems = append(keptFreeItems, uItem)
+		}
+	}
+
+	return keptMmapBytesList, keptFreeItems, releasedSize, err
+}
@@ -77,9 +77,28 @@ type NoGCUintptrPool struct {
 
 // Local per-P Pool appendix.
 type uintptrPoolLocalInternal struct {
-	private    uintptr   // Can be used only by the respective P.
+	private    uintptr   // Can be used only by the respective P, or atomically by Drain.
 	shared     []uintptr // Can be used by any P.
 	sync.Mutex           // Protects shared.
+
+	// idlePrivate is private at the last DrainIdle, and lowWater the lowest
+	// len(shared) since, protected by Mutex.
+	idlePrivate uintptr
+	lowWater    int
+}
+
+// popShared removes the last shared item, l must be locked
+func (l *uintptrPoolLocalInternal) popShared() uintptr {
+	last := len(l.shared) - 1
+	if last < 0 {
+		return 0
+	}
+	x := l.shared[last]
+	l.shared = l.shared[:last]
+	if last < l.lowWater {
+		l.lowWater = last
+	}
+	return x
 }
 
 type uintptrPoolLocal struct {
@@ -104,8 +123,7 @@ func (p *NoGCUintptrPool) Put(x uintptr) {
 		runtime.UnsafeRaceDisable()
 	}
 	l := p.pin()
-	if l.private == 0 {
-		l.private = x
+	if atomic.CompareAndSwapUintptr(&l.private, 0, x) {
 		x = 0
 	}
 	runtime.UnsafeProcUnpin()
@@ -132,16 +150,11 @@ func (p *NoGCUintptrPool) Get() uintptr {
 		runtime.UnsafeRaceDisable()
 	}
 	l := p.pin()
-	x := l.private
-	l.private = 0
+	x := atomic.SwapUintptr(&l.private, 0)
 	runtime.UnsafeProcUnpin()
 	if x == 0 {
 		l.Lock()
-		last := len(l.shared) - 1
-		if last >= 0 {
-			x = l.shared[last]
-			l.shared = l.shared[:last]
-		}
+		x = l.popShared()
 		l.Unlock()
 		if x == 0 {
 			x = p.getSlow()
@@ -169,18 +182,96 @@ func (p *NoGCUintptrPool) getSlow() (x uintptr) {
 	for i := 0; i < int(size); i++ {
 		l := NoGCUintptrPoolIndexLocal(local, (pid+i+1)%int(size))
 		l.Lock()
-		last := len(l.shared) - 1
-		if last >= 0 {
-			x = l.shared[last]
-			l.shared = l.shared[:last]
-			l.Unlock()
+		x = l.popShared()
+		l.Unlock()
+		if x != 0 {
 			break
 		}
-		l.Unlock()
 	}
 	return x
 }
 
+// Drain removes every item from the NoGCUintptrPool and returns them.
+// Items Put concurrently with Drain may be left in the NoGCUintptrPool.
+func (p *NoGCUintptrPool) Drain() []uintptr {
+	var ret []uintptr
+	size := atomic.LoadUintptr(&p.localSize) // load-acquire
+	local := atomic.LoadPointer(&p.local)
+	for i := 0; i < int(size); i++ {
+		l := NoGCUintptrPoolIndexLocal(local, i)
+		if x := atomic.SwapUintptr(&l.private, 0); x != 0 {
+			ret = append(ret, x)
+		}
+		l.Lock()
+		ret = append(ret, l.shared...)
+		l.shared = nil
+		l.lowWater = 0
+		l.Unlock()
+	}
+	return ret
+}
+
+// DrainIdle removes the items which stayed in the NoGCUintptrPool since the
+// last DrainIdle and returns them. Each per-P pool gives the items under the
+// lowest number of items it held meanwhile, so that it keeps the items it
+// keeps reusing and Get seldom falls back to the other per-P pools.
+func (p *NoGCUintptrPool) DrainIdle() []uintptr {
+	var ret []uintptr
+	size := atomic.LoadUintptr(&p.localSize) // load-acquire
+	local := atomic.LoadPointer(&p.local)
+	for i := 0; i < int(size); i++ {
+		l := NoGCUintptrPoolIndexLocal(local, i)
+		l.Lock()
+		// private may be got and put back meanwhile, it is only advised early then
+		if l.idlePrivate != 0 && atomic.CompareAndSwapUintptr(&l.private, l.idlePrivate, 0) {
+			ret = append(ret, l.idlePrivate)
+		}
+		l.idlePrivate = atomic.LoadUintptr(&l.private)
+		// the items under lowWater were not got since the last DrainIdle
+		if l.lowWater > len(l.shared) {
+			l.lowWater = len(l.shared)
+		}
+		ret = append(ret, l.shared[:l.lowWater]...)
+		l.shared = append(l.shared[:0], l.shared[l.lowWater:]...)
+		l.lowWater = len(l.shared)
+		l.Unlock()
+	}
+	return ret
+}
+
+// Len returns the number of items in the NoGCUintptrPool.
+// The result is only a snapshot if Put or Get are called concurrently.
+func (p *NoGCUintptrPool) Len() int {
+	var ret int
+	size := atomic.LoadUintptr(&p.localSize) // load-acquire
+	local := atomic.LoadPointer(&p.local)
+	for i := 0; i < int(size); i++ {
+		l := NoGCUintptrPoolIndexLocal(local, i)
+		if atomic.LoadUintptr(&l.private) != 0 {
+			ret++
+		}
+		l.Lock()
+		ret += len(l.shared)
+		l.Unlock()
+	}
+	return ret
+}
+
+// Reset drops every item of the NoGCUintptrPool and forgets its per-P pools.
+// Reset must not be called concurrently with Put or Get.
+func (p *NoGCUintptrPool) Reset() {
+	allNoGCUintptrPoolsMu.Lock()
+	for i := range allNoGCUintptrPools {
+		if allNoGCUintptrPools[i] == p {
+			allNoGCUintptrPools = append(allNoGCUintptrPools[:i], allNoGCUintptrPools[i+1:]...)
+			break
+		}
+	}
+	atomic.StoreUintptr(&p.localSize, 0)
+	atomic.StorePointer(&p.local, nil)
+	allNoGCUintptrPoolsMu.Unlock()
+}
+
 // pin pins the current goroutine to P, disables preemption and returns uintptrPoolLocal pool for the P.
 // Caller must call runtime.UnsafeProcUnpin() when done with the pool.
 func (p *NoGCUintptrPool) pin() *uintptrPoolLocal {
@@ -42,6 +42,40 @@ func TestNoGCUintptrPool(t *testing.T) {
 	debug.SetGCPercent(100) // to allow following GC to actually run
 }
 
+func TestNoGCUintptrPoolDrainIdle(t *testing.T) {
+	// Make sure that the goroutine doesn't migrate to another P.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	var p NoGCUintptrPool
+	for i := uintptr(1); i <= 10; i++ {
+		p.Put(i)
+	}
+	if g := p.DrainIdle(); len(g) != 0 {
+		t.Fatalf("got %v; want nothing idle before the first DrainIdle", g)
+	}
+
+	// 1 is private, 10 the last shared item
+	if g := p.Get(); g != 1 {
+		t.Fatalf("got %v; want 1", g)
+	}
+	if g := p.Get(); g != 10 {
+		t.Fatalf("got %v; want 10", g)
+	}
+	p.Put(10)
+	p.Put(1)
+	if g := p.DrainIdle(); len(g) != 8 || g[0] != 2 || g[7] != 9 {
+		t.Fatalf("got %v; want 2 to 9", g)
+	}
+	if g := p.Len(); g != 2 {
+		t.Fatalf("got %v; want 2 items kept", g)
+	}
+	if g := p.DrainIdle(); len(g) != 2 {
+		t.Fatalf("got %v; want the 2 items kept", g)
+	}
+	if g := p.Len(); g != 0 {
+		t.Fatalf("got %v; want empty", g)
+	}
+}
+
 func TestNoGCUintptrPoolNew(t *testing.T) {
 	// disable GC so we can control when it happens.
 	defer debug.SetGCPercent(debug.SetGCPercent(-1))
@@ -1,6 +1,9 @@
 package offheap
 
-import "sync/atomic"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 var (
 	DefaultOffheapDriver OffheapDriver
@@ -17,13 +20,21 @@ func init() {
 type OffheapDriver struct {
 	maxTableID int64
 
+	poolsRWMutex  sync.RWMutex
 	chunkPools    map[int64]*ChunkPool
 	rawChunkPools map[int64]*RawChunkPool
+
+	tablesRWMutex sync.RWMutex
+	tables        map[string]OffheapTable
+
+	malloc offheapMalloc
 }
 
 func (p *OffheapDriver) Init() error {
 	p.chunkPools = make(map[int64]*ChunkPool)
 	p.rawChunkPools = make(map[int64]*RawChunkPool)
+	p.tables = make(map[string]OffheapTable)
+	p.malloc.Init()
 	return nil
 }
 
@@ -34,19 +45,34 @@ func (p *OffheapDriver) AllocTableID() int64 {
 func InitRawObjectPool(pool *RawObjectPool,
 	structSize int, rawChunksLimit int32,
 	prepareNewRawChunkFunc RawChunkPoolInvokePrepareNewRawChunk,
-	releaseRawChunkFunc RawChunkPoolInvokeReleaseRawChunk) error {
+	releaseRawChunkFunc RawChunkPoolInvokeReleaseRawChunk,
+	options ...PoolOptions) error {
 
 	return DefaultOffheapDriver.InitRawObjectPool(pool,
 		structSize, rawChunksLimit,
 		prepareNewRawChunkFunc,
-		releaseRawChunkFunc)
+		releaseRawChunkFunc,
+		options...)
 }
 
 func InitChunkPool(pool *ChunkPool, chunkSize int, chunksLimit int32,
 	prepareNewChunkFunc ChunkPoolInvokePrepareNewChunk,
-	releaseChunkFunc ChunkPoolInvokeReleaseChunk) error {
+	releaseChunkFunc ChunkPoolInvokeReleaseChunk,
+	options ...PoolOptions) error {
 
 	return DefaultOffheapDriver.InitChunkPool(pool,
+		chunkSize, chunksLimit,
+		prepareNewChunkFunc,
+		releaseChunkFunc,
+		options...)
+}
+
+func InitChunkPoolWithFile(pool *ChunkPool, path string,
+	chunkSize int, chunksLimit int32,
+	prepareNewChunkFunc ChunkPoolInvokePrepareNewChunk,
+	releaseChunkFunc ChunkPoolInvokeReleaseChunk) error {
+
+	return DefaultOffheapDriver.InitChunkPoolWithFile(pool, path,
 		chunkSize, chunksLimit,
 		prepareNewChunkFunc,
 		releaseChunkFunc)
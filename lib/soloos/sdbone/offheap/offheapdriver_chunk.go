@@ -1,10 +1,24 @@
 package offheap
 
 func (p *OffheapDriver) InitChunkPool(pool *ChunkPool,
+	chunkSize int, chunksLimit int32,
+	prepareNewChunkFunc ChunkPoolInvokePrepareNewChunk,
+	releaseChunkFunc ChunkPoolInvokeReleaseChunk,
+	options ...PoolOptions) error {
+	err := pool.Init(p.AllocTableID(), chunkSize, chunksLimit, prepareNewChunkFunc, releaseChunkFunc, options...)
+	if err != nil {
+		return err
+	}
+
+	p.SetChunkPool(pool)
+	return nil
+}
+
+func (p *OffheapDriver) InitChunkPoolWithFile(pool *ChunkPool, path string,
 	chunkSize int, chunksLimit int32,
 	prepareNewChunkFunc ChunkPoolInvokePrepareNewChunk,
 	releaseChunkFunc ChunkPoolInvokeReleaseChunk) error {
-	err := pool.Init(p.AllocTableID(), chunkSize, chunksLimit, prepareNewChunkFunc, releaseChunkFunc)
+	err := pool.InitWithFile(p.AllocTableID(), path, chunkSize, chunksLimit, prepareNewChunkFunc, releaseChunkFunc)
 	if err != nil {
 		return err
 	}
@@ -14,9 +28,21 @@ func (p *OffheapDriver) InitChunkPool(pool *ChunkPool,
 }
 
 func (p *OffheapDriver) SetChunkPool(chunkPool *ChunkPool) {
+	p.poolsRWMutex.Lock()
+	chunkPool.offheapDriver = p
 	p.chunkPools[chunkPool.ID] = chunkPool
+	p.poolsRWMutex.Unlock()
 }
 
 func (p *OffheapDriver) GetChunkPool(poolid int64) *ChunkPool {
-	return p.chunkPools[poolid]
+	p.poolsRWMutex.RLock()
+	chunkPool := p.chunkPools[poolid]
+	p.poolsRWMutex.RUnlock()
+	return chunkPool
+}
+
+func (p *OffheapDriver) DeleteChunkPool(poolid int64) {
+	p.poolsRWMutex.Lock()
+	delete(p.chunkPools, poolid)
+	p.poolsRWMutex.Unlock()
 }
@@ -1,9 +1,21 @@
 package offheap
 
 func (p *OffheapDriver) SetRawChunkPool(rawChunkPool *RawChunkPool) {
+	p.poolsRWMutex.Lock()
+	rawChunkPool.offheapDriver = p
 	p.rawChunkPools[rawChunkPool.ID] = rawChunkPool
+	p.poolsRWMutex.Unlock()
 }
 
 func (p *OffheapDriver) GetRawChunkPool(poolid int64) *RawChunkPool {
-	return p.rawChunkPools[poolid]
+	p.poolsRWMutex.RLock()
+	rawChunkPool := p.rawChunkPools[poolid]
+	p.poolsRWMutex.RUnlock()
+	return rawChunkPool
+}
+
+func (p *OffheapDriver) DeleteRawChunkPool(poolid int64) {
+	p.poolsRWMutex.Lock()
+	delete(p.rawChunkPools, poolid)
+	p.poolsRWMutex.Unlock()
 }
@@ -3,12 +3,13 @@ package offheap
 func (p *OffheapDriver) InitRawObjectPool(pool *RawObjectPool,
 	structSize int, rawChunksLimit int32,
 	prepareNewRawChunkFunc RawChunkPoolInvokePrepareNewRawChunk,
-	releaseRawChunkFunc RawChunkPoolInvokeReleaseRawChunk) error {
+	releaseRawChunkFunc RawChunkPoolInvokeReleaseRawChunk,
+	options ...PoolOptions) error {
 	var (
 		err error
 	)
 
-	err = pool.Init(p.AllocTableID(), structSize, rawChunksLimit, prepareNewRawChunkFunc, releaseRawChunkFunc)
+	err = pool.Init(p.AllocTableID(), structSize, rawChunksLimit, prepareNewRawChunkFunc, releaseRawChunkFunc, options...)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,89 @@
+package offheap
+
+import "sort"
+
+// OffheapTable is a table registered by name in an OffheapDriver
+type OffheapTable interface {
+	Name() string
+	Stats() HKVTableStats
+	Close() error
+}
+
+// reserveTable books name for a table being created, it returns
+// ErrTableNameCollision if name is already taken
+func (p *OffheapDriver) reserveTable(name string) error {
+	p.tablesRWMutex.Lock()
+	if _, exists := p.tables[name]; exists {
+		p.tablesRWMutex.Unlock()
+		return ErrTableNameCollision
+	}
+	p.tables[name] = nil
+	p.tablesRWMutex.Unlock()
+	return nil
+}
+
+// setTable registers table under the name reserved by reserveTable,
+// or releases the name if table is nil
+func (p *OffheapDriver) setTable(name string, table OffheapTable) {
+	p.tablesRWMutex.Lock()
+	if table == nil {
+		delete(p.tables, name)
+	} else {
+		p.tables[name] = table
+	}
+	p.tablesRWMutex.Unlock()
+}
+
+// deleteTable unregisters table if it is registered as name
+func (p *OffheapDriver) deleteTable(name string, table OffheapTable) {
+	p.tablesRWMutex.Lock()
+	if p.tables[name] == table {
+		delete(p.tables, name)
+	}
+	p.tablesRWMutex.Unlock()
+}
+
+// GetTable returns the table named name, or nil if there is none
+func (p *OffheapDriver) GetTable(name string) OffheapTable {
+	p.tablesRWMutex.RLock()
+	table := p.tables[name]
+	p.tablesRWMutex.RUnlock()
+	return table
+}
+
+// ListTables returns the sorted names of the tables
+func (p *OffheapDriver) ListTables() []string {
+	var names []string
+	p.tablesRWMutex.RLock()
+	for name, table := range p.tables {
+		if table != nil {
+			names = append(names, name)
+		}
+	}
+	p.tablesRWMutex.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// DropTable unregisters the table named name and releases all its memory,
+// it returns ErrTableNotFound if there is none
+func (p *OffheapDriver) DropTable(name string) error {
+	p.tablesRWMutex.Lock()
+	table := p.tables[name]
+	if table != nil {
+		delete(p.tables, name)
+	}
+	p.tablesRWMutex.Unlock()
+
+	if table == nil {
+		return ErrTableNotFound
+	}
+	return table.Close()
+}
+
+// GetHKVTable returns the HKVTable named name with keys of type K,
+// or nil if there is none
+func GetHKVTable[K comparable](offheapDriver *OffheapDriver, name string) *HKVTable[K] {
+	kvTable, _ := offheapDriver.GetTable(name).(*HKVTable[K])
+	return kvTable
+}
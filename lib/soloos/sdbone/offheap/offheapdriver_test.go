@@ -1,7 +1,11 @@
 package offheap
 
 import (
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -9,14 +13,14 @@ import (
 type MockOffheapDriver struct {
 	offheapDriver  *OffheapDriver
 	mockChunkPools map[int32]*MockChunkPool
-	chunks         map[int32]ChunkUintptr
+	chunks         map[int64]ChunkUintptr
 }
 
 func (p *MockOffheapDriver) Init(offheapDriver *OffheapDriver) error {
 	p.offheapDriver = offheapDriver
 	p.offheapDriver.Init()
 	p.mockChunkPools = make(map[int32]*MockChunkPool)
-	p.chunks = make(map[int32]ChunkUintptr)
+	p.chunks = make(map[int64]ChunkUintptr)
 	return nil
 }
 
@@ -70,3 +74,129 @@ func TestOffheapDriverAllocChunk(t *testing.T) {
 		mockOffheapDriver.mockChunkPools[2].ChunkPoolInvokeReleaseChunk()
 	}
 }
+
+func TestOffheapDriverStats(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		chunkPool     ChunkPool
+		rawChunkPool  RawObjectPool
+		chunks        []ChunkUintptr
+		chunksLimit   int32 = 4
+		stats         OffheapDriverStats
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	assert.NoError(t, offheapDriver.InitChunkPool(&chunkPool, 8, chunksLimit, nil, func() {
+		chunkPool.ReleaseChunk(uintptr(chunks[0]))
+		chunks = chunks[1:]
+	}))
+	assert.NoError(t, offheapDriver.InitRawObjectPool(&rawChunkPool, 8, 16, nil, nil))
+
+	for i := int32(0); i <= chunksLimit; i++ {
+		uChunk, err := chunkPool.TryAllocChunk()
+		assert.NoError(t, err)
+		chunks = append(chunks, uChunk)
+	}
+	chunkPool.ReleaseChunk(uintptr(chunks[0]))
+	chunks = chunks[1:]
+	rawChunkPool.AllocRawObject()
+
+	poolStats := chunkPool.Stats()
+	assert.Equal(t, chunkPool.ID, poolStats.ID)
+	assert.Equal(t, chunksLimit-1, poolStats.ActiveChunksNum)
+	assert.Equal(t, int64(1), poolStats.ReleaseChunkInvokeNum)
+	// NoGCUintptrPool drops chunks under race detector, new ones are carved then
+	if runtime.UnsafeRaceEnabled == false {
+		assert.Equal(t, 1, poolStats.FreeChunksNum)
+		assert.Equal(t, int(chunksLimit), poolStats.MmapBytesNum)
+	}
+	assert.Equal(t, poolStats.MmapBytesNum*chunkPool.perMmapBytesSize, poolStats.MmapBytesSize)
+
+	stats = offheapDriver.Stats()
+	assert.Equal(t, 1, len(stats.ChunkPools))
+	assert.Equal(t, 1, len(stats.RawChunkPools))
+	assert.Equal(t, int64(poolStats.ActiveChunksNum+1), stats.ActiveChunksNum)
+	assert.Equal(t, int64(poolStats.FreeChunksNum), stats.FreeChunksNum)
+	assert.Equal(t, int64(1), stats.ReleaseChunkInvokeNum)
+	assert.Equal(t, int64(poolStats.MmapBytesSize+stats.RawChunkPools[0].MmapBytesSize), stats.MmapBytesSize)
+}
+
+func TestOffheapDriverStatsUnlimited(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		chunkPool     ChunkPool
+		uChunk        ChunkUintptr
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	assert.NoError(t, offheapDriver.InitChunkPool(&chunkPool, 64, -1, nil, nil))
+
+	uChunk = chunkPool.AllocChunk()
+	_, err = chunkPool.TryAllocChunk()
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), chunkPool.Stats().ActiveChunksNum)
+	assert.Equal(t, int64(2*64), chunkPool.Stats().BytesInUse)
+
+	chunkPool.ReleaseChunk(uintptr(uChunk))
+	assert.Equal(t, int32(1), chunkPool.Stats().ActiveChunksNum)
+	assert.Equal(t, int64(64), chunkPool.Stats().BytesInUse)
+	assert.Equal(t, int64(1), offheapDriver.Stats().ActiveChunksNum)
+}
+
+func TestOffheapDriverTables(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *HKVTableWithInt64
+		waitGroup     sync.WaitGroup
+		createdNum    int32
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateHKVTableWithInt64("b",
+		int(unsafe.Sizeof(HKVTableObjectWithInt64{})), 16, 4, nil, nil)
+	assert.NoError(t, err)
+	_, err = offheapDriver.CreateHKVTableWithString("b",
+		int(unsafe.Sizeof(HKVTableObjectWithString{})), 16, 4, nil, nil)
+	assert.Equal(t, ErrTableNameCollision, err)
+	_, err = CreateHKVTable[float64](&offheapDriver, "c",
+		int(unsafe.Sizeof(HKVTableObject[float64]{})), 16, 4, nil, nil, nil)
+	assert.Equal(t, ErrUnknownKeyType, err)
+	_, err = offheapDriver.CreateHKVTableWithString("a",
+		int(unsafe.Sizeof(HKVTableObjectWithString{})), 16, 4, nil, nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"a", "b"}, offheapDriver.ListTables())
+	assert.Equal(t, kvTable, offheapDriver.GetTable("b"))
+	assert.Equal(t, kvTable, GetHKVTable[int64](&offheapDriver, "b"))
+	assert.Nil(t, GetHKVTable[string](&offheapDriver, "b"))
+	assert.Nil(t, offheapDriver.GetTable("c"))
+
+	uObject, _, err := kvTable.MustGetObjectWithReadAcquire(1)
+	assert.NoError(t, err)
+	HKVTableObjectUPtrWithInt64(uObject).Ptr().ReadRelease()
+	assert.NotNil(t, offheapDriver.GetRawChunkPool(kvTable.chunkPool.ID))
+	assert.NoError(t, offheapDriver.DropTable("b"))
+	assert.Equal(t, ErrTableNotFound, offheapDriver.DropTable("b"))
+	assert.Nil(t, offheapDriver.GetRawChunkPool(kvTable.chunkPool.ID))
+	assert.Equal(t, []string{"a"}, offheapDriver.ListTables())
+
+	for i := 0; i < 8; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			_, err := offheapDriver.CreateHKVTableWithInt64("d",
+				int(unsafe.Sizeof(HKVTableObjectWithInt64{})), 16, 4, nil, nil)
+			if err == nil {
+				atomic.AddInt32(&createdNum, 1)
+			} else {
+				assert.Equal(t, ErrTableNameCollision, err)
+			}
+		}()
+	}
+	waitGroup.Wait()
+	assert.Equal(t, int32(1), createdNum)
+	assert.NoError(t, GetHKVTable[int64](&offheapDriver, "d").Close())
+	assert.Equal(t, []string{"a"}, offheapDriver.ListTables())
+}
@@ -0,0 +1,453 @@
+package offheap
+
+import (
+	"bytes"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	OKVTableMaxLevel = 24
+
+	okvTableLevelSeedStep = 0x9e3779b97f4a7c15
+)
+
+type OKVTableObjectUPtr uintptr
+
+func (u OKVTableObjectUPtr) Ptr() *OKVTableObject {
+	return (*OKVTableObject)(unsafe.Pointer(u))
+}
+
+// OKVTableObject is the header of the objects of OKVTable, the key of an
+// object is stored right after its objectSize bytes
+type OKVTableObject struct {
+	// tower holds the next objects of the object at each of its levels
+	tower   uintptr
+	level   int32
+	keySize int32
+	HSharedPointer
+}
+
+// Ordered Key-Value table, a skiplist of objects alloced in a RawChunkPool
+// and sorted by byte string keys.
+// The skiplist is guarded by one RWMutex, objects are acquired out of it like in HKVTable.
+// There is no eviction, MustGet fails with ErrAllocChunkOurOfLimit once
+// objectsLimit is reached.
+type OKVTable struct {
+	HKVTableCommon
+	maxKeySize   int
+	registeredAs OffheapTable
+
+	listRWMutex sync.RWMutex
+	head        [OKVTableMaxLevel]uintptr
+	level       int32
+	objectsNum  int64
+	levelSeed   uint64
+}
+
+// CreateOKVTable creates an OKVTable registered as name in p, keys are
+// at most maxKeySize bytes.
+// It returns ErrTableNameCollision if p has a table named name,
+// ErrObjectSizeInvalid if objectSize can not hold an OKVTableObject.
+func (p *OffheapDriver) CreateOKVTable(name string,
+	objectSize int, maxKeySize int, objectsLimit int32,
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+) (*OKVTable, error) {
+	var (
+		kvTable = new(OKVTable)
+		err     error
+	)
+
+	err = p.createOKVTable(kvTable, kvTable, name, objectSize, maxKeySize, objectsLimit,
+		prepareNewObjectFunc, beforeReleaseObjectFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	return kvTable, nil
+}
+
+// createOKVTable inits kvTable and registers it as table
+func (p *OffheapDriver) createOKVTable(kvTable *OKVTable, table OffheapTable, name string,
+	objectSize int, maxKeySize int, objectsLimit int32,
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+) error {
+	var err error
+
+	err = p.reserveTable(name)
+	if err != nil {
+		return err
+	}
+
+	kvTable.offheapDriver = p
+	err = kvTable.Init(name, objectSize, maxKeySize, objectsLimit,
+		prepareNewObjectFunc, beforeReleaseObjectFunc)
+	if err != nil {
+		p.setTable(name, nil)
+		return err
+	}
+	kvTable.registeredAs = table
+	p.setTable(name, table)
+
+	return nil
+}
+
+func (p *OKVTable) Init(name string,
+	objectSize int, maxKeySize int, objectsLimit int32,
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+) error {
+	if objectSize < int(unsafe.Sizeof(OKVTableObject{})) {
+		return ErrObjectSizeInvalid
+	}
+
+	p.name = name
+	p.objectSize = objectSize
+	p.maxKeySize = maxKeySize
+	p.objectsLimit = objectsLimit
+	p.prepareNewObjectFunc = prepareNewObjectFunc
+	p.beforeReleaseObjectFunc = beforeReleaseObjectFunc
+	p.level = 1
+	p.levelSeed = makeHashSeed()
+
+	// the key of an object is stored after its objectSize bytes
+	p.objectSize = objectSize + maxKeySize
+	err := p.initChunkPool(p.chunkPoolInvokePrepareNewChunk, nil)
+	p.objectSize = objectSize
+
+	return err
+}
+
+func (p *OKVTable) Name() string {
+	return p.name
+}
+
+// Stats returns a snapshot of the statistics of the OKVTable
+func (p *OKVTable) Stats() HKVTableStats {
+	return HKVTableStats{
+		Name:       p.name,
+		ObjectsNum: int(atomic.LoadInt64(&p.objectsNum)),
+		PoolStats:  p.chunkPool.Stats(),
+	}
+}
+
+func (p *OKVTable) chunkPoolInvokePrepareNewChunk(uChunk uintptr) {
+	if p.prepareNewObjectFunc != nil {
+		p.prepareNewObjectFunc(uChunk)
+	}
+}
+
+// ObjectKey returns the key of uObject, which must be acquired
+func (p *OKVTable) ObjectKey(uObject uintptr) []byte {
+	var keySize = int(OKVTableObjectUPtr(uObject).Ptr().keySize)
+	if keySize == 0 {
+		return nil
+	}
+	return (*[1 << 30]byte)(unsafe.Pointer(uObject + uintptr(p.objectSize)))[:keySize:keySize]
+}
+
+// next returns the next object of uObject at level, the head if uObject is 0
+func (p *OKVTable) next(uObject uintptr, level int32) uintptr {
+	if uObject == 0 {
+		return p.head[level]
+	}
+	return *(*uintptr)(unsafe.Pointer(OKVTableObjectUPtr(uObject).Ptr().tower + uintptr(level)*unsafe.Sizeof(uintptr(0))))
+}
+
+func (p *OKVTable) setNext(uObject uintptr, level int32, uNext uintptr) {
+	if uObject == 0 {
+		p.head[level] = uNext
+		return
+	}
+	*(*uintptr)(unsafe.Pointer(OKVTableObjectUPtr(uObject).Ptr().tower + uintptr(level)*unsafe.Sizeof(uintptr(0)))) = uNext
+}
+
+func (p *OKVTable) randomLevel() int32 {
+	var (
+		seed  = atomic.AddUint64(&p.levelSeed, okvTableLevelSeedStep)
+		level = int32(bits.TrailingZeros64(hashMix(seed, hashKey1)|1<<62)/2 + 1)
+	)
+	if level > OKVTableMaxLevel {
+		level = OKVTableMaxLevel
+	}
+	return level
+}
+
+// findLess returns the last object with a key less than key, or 0 if there is
+// none, and fills prevs with the last object less than key at each level if
+// it is not nil. It must be called with listRWMutex locked.
+func (p *OKVTable) findLess(key []byte, prevs *[OKVTableMaxLevel]uintptr) uintptr {
+	var (
+		uObject uintptr
+		uNext   uintptr
+		level   int32
+	)
+
+	for level = p.level - 1; level >= 0; level-- {
+		for {
+			uNext = p.next(uObject, level)
+			if uNext == 0 || bytes.Compare(p.ObjectKey(uNext), key) >= 0 {
+				break
+			}
+			uObject = uNext
+		}
+		if prevs != nil {
+			prevs[level] = uObject
+		}
+	}
+
+	return uObject
+}
+
+// findLessOrEqual returns the last object with a key less or equal to key,
+// or 0 if there is none. It must be called with listRWMutex locked.
+func (p *OKVTable) findLessOrEqual(key []byte) uintptr {
+	var uObject = p.findLess(key, nil)
+	if uNext := p.next(uObject, 0); uNext != 0 && bytes.Equal(p.ObjectKey(uNext), key) {
+		return uNext
+	}
+	return uObject
+}
+
+// findLast returns the object with the greatest key, or 0 if the OKVTable is
+// empty. It must be called with listRWMutex locked.
+func (p *OKVTable) findLast() uintptr {
+	var (
+		uObject uintptr
+		level   int32
+	)
+
+	for level = p.level - 1; level >= 0; level-- {
+		for p.next(uObject, level) != 0 {
+			uObject = p.next(uObject, level)
+		}
+	}
+
+	return uObject
+}
+
+func (p *OKVTable) acquireObject(uObject uintptr, isWrite bool) {
+	if isWrite {
+		OKVTableObjectUPtr(uObject).Ptr().WriteAcquire()
+	} else {
+		OKVTableObjectUPtr(uObject).Ptr().ReadAcquire()
+	}
+}
+
+func (p *OKVTable) releaseObject(uObject uintptr, isWrite bool) {
+	if isWrite {
+		OKVTableObjectUPtr(uObject).Ptr().WriteRelease()
+	} else {
+		OKVTableObjectUPtr(uObject).Ptr().ReadRelease()
+	}
+}
+
+func (p *OKVTable) checkObject(uObject uintptr, key []byte) bool {
+	return OKVTableObjectUPtr(uObject).Ptr().IsInited() && bytes.Equal(p.ObjectKey(uObject), key)
+}
+
+func (p *OKVTable) allocObjectWithAcquire(key []byte, isWrite bool) (uintptr, error) {
+	var (
+		uObject uintptr
+		level   = p.randomLevel()
+		err     error
+	)
+
+	uObject, err = p.chunkPool.TryAllocRawChunk()
+	if err != nil {
+		return 0, err
+	}
+
+	OKVTableObjectUPtr(uObject).Ptr().tower, err = p.mallocOffheapDriver().Malloc(int(level) * int(unsafe.Sizeof(uintptr(0))))
+	if err != nil {
+		p.chunkPool.ReleaseRawChunk(uObject)
+		return 0, err
+	}
+	OKVTableObjectUPtr(uObject).Ptr().level = level
+	OKVTableObjectUPtr(uObject).Ptr().keySize = int32(len(key))
+	copy((*[1 << 30]byte)(unsafe.Pointer(uObject + uintptr(p.objectSize)))[:len(key):len(key)], key)
+	p.acquireObject(uObject, isWrite)
+	OKVTableObjectUPtr(uObject).Ptr().CompleteInit()
+
+	return uObject, nil
+}
+
+// freeObject releases an acquired object which is not linked in the skiplist
+func (p *OKVTable) freeObject(uObject uintptr, isWrite bool) {
+	p.mallocOffheapDriver().Free(OKVTableObjectUPtr(uObject).Ptr().tower)
+	OKVTableObjectUPtr(uObject).Ptr().Reset()
+	p.releaseObject(uObject, isWrite)
+	p.chunkPool.ReleaseRawChunk(uObject)
+}
+
+// MustGetObjectWithReadAcquire get or init the object of key
+// The bool result is true if the object was loaded, false if alloc.
+// The error result is ErrKeyTooLong, ErrMmap or ErrAllocChunkOurOfLimit if
+// the object could not be alloc.
+func (p *OKVTable) MustGetObjectWithReadAcquire(key []byte) (uintptr, bool, error) {
+	return p.mustGetObjectWithAcquire(key, false)
+}
+
+// MustGetObjectWithWriteAcquire is MustGetObjectWithReadAcquire with the object
+// write acquired, to be released by WriteRelease
+func (p *OKVTable) MustGetObjectWithWriteAcquire(key []byte) (uintptr, bool, error) {
+	return p.mustGetObjectWithAcquire(key, true)
+}
+
+func (p *OKVTable) mustGetObjectWithAcquire(key []byte, isWrite bool) (uintptr, bool, error) {
+	var (
+		uObject    uintptr
+		uNewObject uintptr
+		prevs      [OKVTableMaxLevel]uintptr
+		level      int32
+		err        error
+	)
+
+	if len(key) > p.maxKeySize {
+		return 0, false, ErrKeyTooLong
+	}
+
+	uObject = p.tryGetObjectWithAcquire(key, isWrite)
+	if uObject != 0 {
+		return uObject, true, nil
+	}
+
+	uNewObject, err = p.allocObjectWithAcquire(key, isWrite)
+	if err != nil {
+		return 0, false, err
+	}
+
+	for {
+		p.listRWMutex.Lock()
+		uObject = p.next(p.findLess(key, &prevs), 0)
+		if uObject == 0 || bytes.Equal(p.ObjectKey(uObject), key) == false {
+			for level = p.level; level < p.objectLevel(uNewObject); level++ {
+				prevs[level] = 0
+			}
+			if p.objectLevel(uNewObject) > p.level {
+				p.level = p.objectLevel(uNewObject)
+			}
+			for level = 0; level < p.objectLevel(uNewObject); level++ {
+				p.setNext(uNewObject, level, p.next(prevs[level], level))
+				p.setNext(prevs[level], level, uNewObject)
+			}
+			atomic.AddInt64(&p.objectsNum, 1)
+			p.listRWMutex.Unlock()
+			return uNewObject, false, nil
+		}
+		p.listRWMutex.Unlock()
+
+		p.acquireObject(uObject, isWrite)
+		if p.checkObject(uObject, key) {
+			p.freeObject(uNewObject, isWrite)
+			return uObject, true, nil
+		}
+		// deleted meanwhile
+		p.releaseObject(uObject, isWrite)
+	}
+}
+
+// objectLevel returns the number of levels linking uObject in the skiplist
+func (p *OKVTable) objectLevel(uObject uintptr) int32 {
+	return OKVTableObjectUPtr(uObject).Ptr().level
+}
+
+// TryGetObjectWithReadAcquire returns the object of key read acquired, or 0 if there is none
+func (p *OKVTable) TryGetObjectWithReadAcquire(key []byte) uintptr {
+	return p.tryGetObjectWithAcquire(key, false)
+}
+
+// TryGetObjectWithWriteAcquire returns the object of key write acquired, or 0 if there is none
+func (p *OKVTable) TryGetObjectWithWriteAcquire(key []byte) uintptr {
+	return p.tryGetObjectWithAcquire(key, true)
+}
+
+func (p *OKVTable) tryGetObjectWithAcquire(key []byte, isWrite bool) uintptr {
+	var uObject uintptr
+
+	for {
+		p.listRWMutex.RLock()
+		uObject = p.next(p.findLess(key, nil), 0)
+		if uObject != 0 && bytes.Equal(p.ObjectKey(uObject), key) == false {
+			uObject = 0
+		}
+		p.listRWMutex.RUnlock()
+
+		if uObject == 0 {
+			return 0
+		}
+
+		p.acquireObject(uObject, isWrite)
+		if p.checkObject(uObject, key) {
+			return uObject
+		}
+		p.releaseObject(uObject, isWrite)
+	}
+}
+
+// DeleteObject deletes the object of key, through beforeReleaseObjectFunc,
+// and returns whether it is deleted
+func (p *OKVTable) DeleteObject(key []byte) bool {
+	var (
+		uObject uintptr
+		prevs   [OKVTableMaxLevel]uintptr
+		level   int32
+	)
+
+	uObject = p.TryGetObjectWithWriteAcquire(key)
+	if uObject == 0 {
+		return false
+	}
+
+	for {
+		if p.beforeReleaseObjectFunc != nil {
+			p.beforeReleaseObjectFunc(uObject)
+		} else {
+			OKVTableObjectUPtr(uObject).Ptr().SetReleasable()
+		}
+		if OKVTableObjectUPtr(uObject).Ptr().IsShouldRelease() {
+			break
+		}
+	}
+
+	p.listRWMutex.Lock()
+	p.findLess(key, &prevs)
+	for level = 0; level < p.objectLevel(uObject); level++ {
+		p.setNext(prevs[level], level, p.next(uObject, level))
+	}
+	for p.level > 1 && p.head[p.level-1] == 0 {
+		p.level--
+	}
+	atomic.AddInt64(&p.objectsNum, -1)
+	p.listRWMutex.Unlock()
+
+	p.freeObject(uObject, true)
+
+	return true
+}
+
+// Close unregisters the OKVTable from its OffheapDriver and releases its memory,
+// objects must not be used after Close
+func (p *OKVTable) Close() error {
+	var uObject, uNext uintptr
+
+	if p.offheapDriver != nil {
+		p.offheapDriver.deleteTable(p.name, p.registeredAs)
+	}
+
+	p.listRWMutex.Lock()
+	for uObject = p.head[0]; uObject != 0; uObject = uNext {
+		uNext = p.next(uObject, 0)
+		p.mallocOffheapDriver().Free(OKVTableObjectUPtr(uObject).Ptr().tower)
+	}
+	p.head = [OKVTableMaxLevel]uintptr{}
+	p.level = 1
+	atomic.StoreInt64(&p.objectsNum, 0)
+	p.listRWMutex.Unlock()
+
+	return p.chunkPool.Close()
+}
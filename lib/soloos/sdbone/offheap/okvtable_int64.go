@@ -0,0 +1,72 @@
+package offheap
+
+import "encoding/binary"
+
+// OKVTableWithInt64 is an OKVTable keyed by int64, keys are stored by
+// EncodeOKVTableInt64Key so that their byte order is their numeric order
+type OKVTableWithInt64 struct {
+	OKVTable
+}
+
+// EncodeOKVTableInt64Key returns the big endian bytes of k with the sign bit flipped
+func EncodeOKVTableInt64Key(k int64) [8]byte {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], uint64(k)^(1<<63))
+	return key
+}
+
+// DecodeOKVTableInt64Key is the reverse of EncodeOKVTableInt64Key
+func DecodeOKVTableInt64Key(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key) ^ (1 << 63))
+}
+
+func (p *OffheapDriver) CreateOKVTableWithInt64(name string,
+	objectSize int, objectsLimit int32,
+	prepareNewObjectFunc HKVTableInvokePrepareNewObject,
+	beforeReleaseObjectFunc HKVTableInvokeBeforeReleaseObject,
+) (*OKVTableWithInt64, error) {
+	var (
+		kvTable = new(OKVTableWithInt64)
+		err     error
+	)
+
+	err = p.createOKVTable(&kvTable.OKVTable, kvTable, name, objectSize, 8, objectsLimit,
+		prepareNewObjectFunc, beforeReleaseObjectFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	return kvTable, nil
+}
+
+func (p *OKVTableWithInt64) MustGetObjectWithReadAcquire(k int64) (uintptr, bool, error) {
+	key := EncodeOKVTableInt64Key(k)
+	return p.OKVTable.MustGetObjectWithReadAcquire(key[:])
+}
+
+func (p *OKVTableWithInt64) MustGetObjectWithWriteAcquire(k int64) (uintptr, bool, error) {
+	key := EncodeOKVTableInt64Key(k)
+	return p.OKVTable.MustGetObjectWithWriteAcquire(key[:])
+}
+
+func (p *OKVTableWithInt64) TryGetObjectWithReadAcquire(k int64) uintptr {
+	key := EncodeOKVTableInt64Key(k)
+	return p.OKVTable.TryGetObjectWithReadAcquire(key[:])
+}
+
+func (p *OKVTableWithInt64) TryGetObjectWithWriteAcquire(k int64) uintptr {
+	key := EncodeOKVTableInt64Key(k)
+	return p.OKVTable.TryGetObjectWithWriteAcquire(key[:])
+}
+
+func (p *OKVTableWithInt64) DeleteObject(k int64) bool {
+	key := EncodeOKVTableInt64Key(k)
+	return p.OKVTable.DeleteObject(key[:])
+}
+
+// SeekInt64 is OKVTable.Seek with an int64 key, the keys of the iterator are
+// decoded by DecodeOKVTableInt64Key
+func (p *OKVTableWithInt64) SeekInt64(k int64, isReverse bool) *OKVTableIterator {
+	key := EncodeOKVTableInt64Key(k)
+	return p.OKVTable.Seek(key[:], isReverse)
+}
@@ -0,0 +1,107 @@
+package offheap
+
+// OKVTableIterator walks the objects of an OKVTable in key order, or in
+// reverse key order. The current object is read acquired until the iterator
+// moves or is closed, objects added or deleted concurrently may be missed.
+type OKVTableIterator struct {
+	kvTable   *OKVTable
+	isReverse bool
+	uObject   uintptr
+	key       []byte
+}
+
+// Seek returns an iterator at the first object with a key greater or equal
+// to key, or at the last object with a key less or equal to key if isReverse
+func (p *OKVTable) Seek(key []byte, isReverse bool) *OKVTableIterator {
+	var it = &OKVTableIterator{kvTable: p, isReverse: isReverse}
+	it.seek(key, true, false)
+	return it
+}
+
+// SeekFirst returns an iterator at the object with the least key
+func (p *OKVTable) SeekFirst() *OKVTableIterator {
+	return p.Seek(nil, false)
+}
+
+// SeekLast returns a reverse iterator at the object with the greatest key
+func (p *OKVTable) SeekLast() *OKVTableIterator {
+	var it = &OKVTableIterator{kvTable: p, isReverse: true}
+	it.seek(nil, true, true)
+	return it
+}
+
+// seek moves the iterator to the object next to key in its direction,
+// key included if isInclusive, or to the last object if isLast
+func (p *OKVTableIterator) seek(key []byte, isInclusive bool, isLast bool) {
+	var (
+		kvTable = p.kvTable
+		uObject uintptr
+	)
+
+	for {
+		kvTable.listRWMutex.RLock()
+		switch {
+		case isLast:
+			uObject = kvTable.findLast()
+		case p.isReverse && isInclusive:
+			uObject = kvTable.findLessOrEqual(key)
+		case p.isReverse:
+			uObject = kvTable.findLess(key, nil)
+		case isInclusive:
+			uObject = kvTable.next(kvTable.findLess(key, nil), 0)
+		default:
+			uObject = kvTable.next(kvTable.findLessOrEqual(key), 0)
+		}
+		if uObject != 0 {
+			p.key = append(p.key[:0], kvTable.ObjectKey(uObject)...)
+		}
+		kvTable.listRWMutex.RUnlock()
+
+		if uObject == 0 {
+			p.uObject = 0
+			return
+		}
+
+		kvTable.acquireObject(uObject, false)
+		if kvTable.checkObject(uObject, p.key) {
+			p.uObject = uObject
+			return
+		}
+		// deleted meanwhile, skipped
+		kvTable.releaseObject(uObject, false)
+		key, isInclusive, isLast = p.key, false, false
+	}
+}
+
+// Valid is true if the iterator is at an object
+func (p *OKVTableIterator) Valid() bool {
+	return p.uObject != 0
+}
+
+// Object returns the current object, which is read acquired
+func (p *OKVTableIterator) Object() uintptr {
+	return p.uObject
+}
+
+// Key returns the key of the current object, valid until the iterator moves
+func (p *OKVTableIterator) Key() []byte {
+	return p.key
+}
+
+// Next moves the iterator to the following object in its direction
+func (p *OKVTableIterator) Next() {
+	if p.uObject == 0 {
+		return
+	}
+	p.kvTable.releaseObject(p.uObject, false)
+	p.uObject = 0
+	p.seek(p.key, false, false)
+}
+
+// Close releases the current object
+func (p *OKVTableIterator) Close() {
+	if p.uObject != 0 {
+		p.kvTable.releaseObject(p.uObject, false)
+		p.uObject = 0
+	}
+}
@@ -0,0 +1,157 @@
+package offheap
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOKVTable(t *testing.T) {
+	type object struct {
+		OKVTableObject
+		Value int64
+	}
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *OKVTable
+		keys          []string
+		it            *OKVTableIterator
+		uObject       uintptr
+		loaded        bool
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	_, err = offheapDriver.CreateOKVTable("ordered", int(unsafe.Sizeof(OKVTableObject{}))-1, 16, 1024, nil, nil)
+	assert.Equal(t, ErrObjectSizeInvalid, err)
+	kvTable, err = offheapDriver.CreateOKVTable("ordered", int(unsafe.Sizeof(object{})), 16, 1024, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, kvTable, offheapDriver.GetTable("ordered"))
+
+	_, _, err = kvTable.MustGetObjectWithReadAcquire(make([]byte, 17))
+	assert.Equal(t, ErrKeyTooLong, err)
+
+	for _, i := range rand.Perm(500) {
+		key := fmt.Sprintf("key%04d", i*2)
+		keys = append(keys, key)
+		uObject, loaded, err = kvTable.MustGetObjectWithWriteAcquire([]byte(key))
+		assert.NoError(t, err)
+		assert.False(t, loaded)
+		(*object)(unsafe.Pointer(uObject)).Value = int64(i * 2)
+		OKVTableObjectUPtr(uObject).Ptr().WriteRelease()
+	}
+	sort.Strings(keys)
+	assert.Equal(t, 500, kvTable.Stats().ObjectsNum)
+
+	uObject, loaded, err = kvTable.MustGetObjectWithReadAcquire([]byte("key0010"))
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, int64(10), (*object)(unsafe.Pointer(uObject)).Value)
+	OKVTableObjectUPtr(uObject).Ptr().ReadRelease()
+	assert.Equal(t, uintptr(0), kvTable.TryGetObjectWithReadAcquire([]byte("key0011")))
+
+	var got []string
+	for it = kvTable.SeekFirst(); it.Valid(); it.Next() {
+		got = append(got, string(it.Key()))
+	}
+	it.Close()
+	assert.Equal(t, keys, got)
+
+	got = got[:0]
+	for it = kvTable.SeekLast(); it.Valid(); it.Next() {
+		got = append(got, string(it.Key()))
+	}
+	assert.Equal(t, len(keys), len(got))
+	assert.Equal(t, keys[len(keys)-1], got[0])
+	assert.Equal(t, keys[0], got[len(got)-1])
+
+	it = kvTable.Seek([]byte("key0011"), false)
+	assert.Equal(t, "key0012", string(it.Key()))
+	assert.Equal(t, int64(12), (*object)(unsafe.Pointer(it.Object())).Value)
+	it.Close()
+	it = kvTable.Seek([]byte("key0011"), true)
+	assert.Equal(t, "key0010", string(it.Key()))
+	it.Next()
+	assert.Equal(t, "key0008", string(it.Key()))
+	it.Close()
+	it = kvTable.Seek([]byte("key0012"), true)
+	assert.Equal(t, "key0012", string(it.Key()))
+	it.Close()
+	it = kvTable.Seek([]byte("key9"), false)
+	assert.False(t, it.Valid())
+
+	for i := 0; i < 1000; i += 4 {
+		assert.True(t, kvTable.DeleteObject([]byte(fmt.Sprintf("key%04d", i))))
+	}
+	assert.False(t, kvTable.DeleteObject([]byte("key0000")))
+	assert.Equal(t, 250, kvTable.Stats().ObjectsNum)
+	got = got[:0]
+	for it = kvTable.SeekFirst(); it.Valid(); it.Next() {
+		assert.NotEqual(t, 0, bytes.Compare([]byte("key0000"), it.Key()))
+		got = append(got, string(it.Key()))
+	}
+	assert.Equal(t, 250, len(got))
+	assert.True(t, sort.StringsAreSorted(got))
+
+	assert.NoError(t, offheapDriver.DropTable("ordered"))
+	assert.Nil(t, offheapDriver.GetTable("ordered"))
+}
+
+func TestOKVTableWithInt64(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		kvTable       *OKVTableWithInt64
+		waitGroup     sync.WaitGroup
+		it            *OKVTableIterator
+		prev          int64
+		err           error
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	kvTable, err = offheapDriver.CreateOKVTableWithInt64("int64",
+		int(unsafe.Sizeof(OKVTableObject{})), 4096, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, kvTable, offheapDriver.GetTable("int64"))
+
+	for i := 0; i < 4; i++ {
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			for n := 0; n < 500; n++ {
+				k := int64(rand.Intn(2000) - 1000)
+				uObject, _, err := kvTable.MustGetObjectWithReadAcquire(k)
+				assert.NoError(t, err)
+				OKVTableObjectUPtr(uObject).Ptr().ReadRelease()
+				if n%3 == 0 {
+					kvTable.DeleteObject(k)
+				}
+			}
+		}(i)
+	}
+	waitGroup.Wait()
+
+	prev = -1 << 63
+	objectsNum := 0
+	for it = kvTable.SeekInt64(-1<<63, false); it.Valid(); it.Next() {
+		k := DecodeOKVTableInt64Key(it.Key())
+		assert.True(t, k > prev || objectsNum == 0)
+		prev = k
+		objectsNum++
+	}
+	assert.Equal(t, kvTable.Stats().ObjectsNum, objectsNum)
+
+	uObject, _, err := kvTable.MustGetObjectWithReadAcquire(-5)
+	assert.NoError(t, err)
+	OKVTableObjectUPtr(uObject).Ptr().ReadRelease()
+	it = kvTable.SeekInt64(-5, true)
+	assert.Equal(t, int64(-5), DecodeOKVTableInt64Key(it.Key()))
+	it.Close()
+	assert.NoError(t, kvTable.Close())
+	assert.Nil(t, offheapDriver.GetTable("int64"))
+}
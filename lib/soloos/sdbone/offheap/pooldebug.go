@@ -0,0 +1,166 @@
+package offheap
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+const (
+	DefaultDebugQuarantineSize = 256
+	DebugPoisonByte            = 0xdb
+
+	debugCanarySize = 8
+)
+
+var debugCanary = [debugCanarySize]byte{0xca, 0xfe, 0xf0, 0x0d, 0xca, 0xfe, 0xf0, 0x0d}
+
+// PoolDebugError is reported by a pool in debug mode, see PoolOptions.Debug
+type PoolDebugError struct {
+	PoolID int64
+	Chunk  uintptr
+	Err    error
+}
+
+func (p *PoolDebugError) Error() string {
+	return fmt.Sprintf("pool %d chunk 0x%x: %s", p.PoolID, p.Chunk, p.Err.Error())
+}
+
+// poolDebugger checks the chunks of a pool in debug mode.
+// The bytes [dataOffset, dataOffset+dataSize) of a chunk belong to the user,
+// they are followed by a canary which must be left untouched, and are
+// poisoned with DebugPoisonByte while the chunk is in quarantine.
+type poolDebugger struct {
+	poolID     int64
+	dataOffset uintptr
+	dataSize   uintptr
+
+	quarantineSize int
+	reportFunc     func(err error)
+
+	mutex sync.Mutex
+	// chunks is true if the chunk is alloced, false if released
+	chunks     map[uintptr]bool
+	quarantine []uintptr
+}
+
+// debugChunkSize returns the size of a chunk of chunkSize bytes followed by
+// its canary, rounded up to 8 bytes so that the atomics of chunk headers stay aligned
+func debugChunkSize(chunkSize uintptr) uintptr {
+	return (chunkSize + debugCanarySize + 7) &^ 7
+}
+
+func newPoolDebugger(poolID int64, dataOffset, dataSize uintptr, options PoolOptions) *poolDebugger {
+	var ret = &poolDebugger{
+		poolID:         poolID,
+		dataOffset:     dataOffset,
+		dataSize:       dataSize,
+		quarantineSize: options.DebugQuarantineSize,
+		reportFunc:     options.DebugReportFunc,
+		chunks:         make(map[uintptr]bool),
+	}
+	if ret.quarantineSize == 0 {
+		ret.quarantineSize = DefaultDebugQuarantineSize
+	}
+	return ret
+}
+
+func (p *poolDebugger) report(uChunk uintptr, err error) {
+	var debugErr = &PoolDebugError{PoolID: p.poolID, Chunk: uChunk, Err: err}
+	if p.reportFunc == nil {
+		panic(debugErr)
+	}
+	p.reportFunc(debugErr)
+}
+
+func (p *poolDebugger) data(uChunk uintptr) []byte {
+	return (*[1 << 40]byte)(unsafe.Pointer(uChunk + p.dataOffset))[:p.dataSize:p.dataSize]
+}
+
+func (p *poolDebugger) canary(uChunk uintptr) *[debugCanarySize]byte {
+	return (*[debugCanarySize]byte)(unsafe.Pointer(uChunk + p.dataOffset + p.dataSize))
+}
+
+// alloc marks uChunk alloced
+func (p *poolDebugger) alloc(uChunk uintptr) {
+	*p.canary(uChunk) = debugCanary
+	p.mutex.Lock()
+	p.chunks[uChunk] = true
+	p.mutex.Unlock()
+}
+
+// release checks and poisons uChunk, then puts it in quarantine.
+// It returns the chunk leaving quarantine, which may be 0, and false if
+// uChunk must not be released.
+func (p *poolDebugger) release(uChunk uintptr) (uintptr, bool) {
+	var (
+		alloced bool
+		exists  bool
+		uFree   uintptr
+	)
+
+	p.mutex.Lock()
+	alloced, exists = p.chunks[uChunk]
+	if alloced {
+		p.chunks[uChunk] = false
+	}
+	p.mutex.Unlock()
+
+	if exists == false {
+		p.report(uChunk, ErrChunkInvalidRelease)
+		return 0, false
+	}
+	if alloced == false {
+		p.report(uChunk, ErrChunkDoubleRelease)
+		return 0, false
+	}
+
+	if *p.canary(uChunk) != debugCanary {
+		p.report(uChunk, ErrChunkOverflow)
+	}
+
+	data := p.data(uChunk)
+	for i := range data {
+		data[i] = DebugPoisonByte
+	}
+
+	p.mutex.Lock()
+	p.quarantine = append(p.quarantine, uChunk)
+	if len(p.quarantine) > p.quarantineSize {
+		uFree = p.quarantine[0]
+		p.quarantine = p.quarantine[1:]
+	}
+	p.mutex.Unlock()
+
+	if uFree != 0 {
+		p.checkPoison(uFree)
+	}
+
+	return uFree, true
+}
+
+// checkPoison reports ErrChunkUseAfterRelease if uChunk was written in quarantine
+func (p *poolDebugger) checkPoison(uChunk uintptr) {
+	for _, b := range p.data(uChunk) {
+		if b != DebugPoisonByte {
+			p.report(uChunk, ErrChunkUseAfterRelease)
+			return
+		}
+	}
+	if *p.canary(uChunk) != debugCanary {
+		p.report(uChunk, ErrChunkUseAfterRelease)
+	}
+}
+
+// flush empties the quarantine after checking its chunks, and returns them
+func (p *poolDebugger) flush() []uintptr {
+	p.mutex.Lock()
+	ret := p.quarantine
+	p.quarantine = nil
+	p.mutex.Unlock()
+
+	for _, uChunk := range ret {
+		p.checkPoison(uChunk)
+	}
+	return ret
+}
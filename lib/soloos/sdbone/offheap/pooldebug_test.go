@@ -0,0 +1,86 @@
+package offheap
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawChunkPoolDebug(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		pool          RawObjectPool
+		errs          []error
+		rawChunkSize  = 12
+		options       = PoolOptions{
+			Debug:               true,
+			DebugQuarantineSize: 1,
+			DebugReportFunc:     func(err error) { errs = append(errs, err) },
+		}
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	assert.NoError(t, offheapDriver.InitRawObjectPool(&pool, rawChunkSize, 16, nil, nil, options))
+
+	checkErr := func(uRawChunk uintptr, expected error) {
+		if assert.Equal(t, 1, len(errs)) {
+			debugErr := errs[0].(*PoolDebugError)
+			assert.Equal(t, pool.rawChunkPool.ID, debugErr.PoolID)
+			assert.Equal(t, uRawChunk, debugErr.Chunk)
+			assert.Equal(t, expected, debugErr.Err)
+		}
+		errs = nil
+	}
+
+	uA := pool.AllocRawObject()
+	bytesA := (*[1 << 20]byte)(unsafe.Pointer(uA))[:rawChunkSize]
+	for i := range bytesA {
+		bytesA[i] = 1
+	}
+	pool.ReleaseRawObject(uA)
+	assert.Equal(t, 0, len(errs))
+	assert.Equal(t, byte(DebugPoisonByte), bytesA[0])
+
+	pool.ReleaseRawObject(uA)
+	checkErr(uA, ErrChunkDoubleRelease)
+
+	pool.ReleaseRawObject(uA + 1)
+	checkErr(uA+1, ErrChunkInvalidRelease)
+
+	uB := pool.AllocRawObject()
+	(*[1 << 20]byte)(unsafe.Pointer(uB))[rawChunkSize] = 1
+	pool.ReleaseRawObject(uB)
+	checkErr(uB, ErrChunkOverflow)
+
+	// uB is in quarantine
+	(*[1 << 20]byte)(unsafe.Pointer(uB))[0] = 1
+	uC := pool.AllocRawObject()
+	pool.ReleaseRawObject(uC)
+	checkErr(uB, ErrChunkUseAfterRelease)
+
+	assert.Equal(t, int32(0), pool.rawChunkPool.Stats().ActiveChunksNum)
+	assert.Equal(t, rawChunkSize, pool.rawChunkPool.Stats().ChunkSize)
+	// the canary of 8 bytes is padded to keep raw chunks aligned
+	assert.Equal(t, uintptr(24), pool.rawChunkPool.rawChunkSize)
+}
+
+func TestChunkPoolDebug(t *testing.T) {
+	var (
+		offheapDriver OffheapDriver
+		chunkPool     ChunkPool
+	)
+
+	assert.NoError(t, offheapDriver.Init())
+	assert.NoError(t, offheapDriver.InitChunkPool(&chunkPool, 16, -1, nil, nil,
+		PoolOptions{Debug: true}))
+
+	uChunk := chunkPool.AllocChunk()
+	assert.Equal(t, uintptr(uChunk)+ChunkStructSize, uChunk.Ptr().Data)
+	chunkPool.ReleaseChunk(uintptr(uChunk))
+	assert.PanicsWithError(t, (&PoolDebugError{
+		PoolID: chunkPool.ID,
+		Chunk:  uintptr(uChunk),
+		Err:    ErrChunkDoubleRelease,
+	}).Error(), func() { chunkPool.ReleaseChunk(uintptr(uChunk)) })
+}
@@ -0,0 +1,52 @@
+package offheap
+
+import "time"
+
+const (
+	MmapHugePageNone = iota
+	MmapHugePageTransparent
+	MmapHugePageHugeTLB
+)
+
+// PoolOptions are the options of ChunkPool and RawChunkPool
+type PoolOptions struct {
+	// HugePage is one of MmapHugePageNone, MmapHugePageTransparent and MmapHugePageHugeTLB.
+	// MmapHugePageHugeTLB falls back to MmapHugePageTransparent if no huge page is
+	// reserved, MmapHugePageTransparent falls back to MmapHugePageNone if
+	// transparent huge pages are disabled.
+	HugePage int
+
+	// FreeChunkIdleDuration, if not zero, is how long a chunk can stay free before
+	// its pages are given back to the kernel by MADV_DONTNEED.
+	// prepareNewChunkFunc is invoked again when such a chunk is alloced.
+	FreeChunkIdleDuration time.Duration
+
+	// DisableArenas makes every alloc carve its chunk from the current mmap
+	// region of the pool, instead of from an arena of ArenaItemsNum chunks
+	// owned by the current P.
+	DisableArenas bool
+
+	// MemoryBudget, if not nil, limits the bytes of the active raw chunks of
+	// the RawChunkPool together with the other pools of the budget, on top of
+	// rawChunksLimit which can be -1 then. It is ignored by ChunkPool.
+	MemoryBudget *MemoryBudget
+
+	// Debug enables the checks of chunks, which are slow:
+	// a canary follows every chunk to catch overflows, and a guard page ends
+	// every mmap region, not every chunk, to catch the ones past its last chunk.
+	// Released chunks are poisoned with DebugPoisonByte and kept in a quarantine
+	// of DebugQuarantineSize chunks to catch writes after release, and
+	// releasing a chunk twice is reported.
+	// Errors are reported as *PoolDebugError to DebugReportFunc, which
+	// panics if nil. HugePage is ignored in debug mode.
+	Debug               bool
+	DebugQuarantineSize int
+	DebugReportFunc     func(err error)
+}
+
+func getPoolOptions(options []PoolOptions) PoolOptions {
+	if len(options) == 0 {
+		return PoolOptions{}
+	}
+	return options[0]
+}
@@ -1,9 +1,11 @@
 package offheap
 
 import (
+	"context"
 	"math"
 	"sync"
 	"sync/atomic"
+	"syscall"
 )
 
 type RawChunkPoolInvokePrepareNewRawChunk func(uRawChunk uintptr)
@@ -13,7 +15,9 @@ type RawChunkPoolInvokeReleaseRawChunk func()
 // user -> AllocRawChunk -> mallocRawChunk -> user
 // user -> AllocRawChunk -> RawChunkPoolAssistant.RawChunkPoolInvokeReleaseRawChunk -> ReleaseRawChunk -> user
 type RawChunkPool struct {
-	ID int64
+	ID            int64
+	offheapDriver *OffheapDriver
+	options       PoolOptions
 
 	rawChunkSize   uintptr
 	rawChunksLimit int32
@@ -25,27 +29,47 @@ type RawChunkPool struct {
 	currentMmapBytes *mmapbytes
 	mmapBytesList    []*mmapbytes
 
-	maxRawChunkID      int64
-	rawChunksMutex     sync.Mutex
-	activeRawChunksNum int32
-	pool               NoGCUintptrPool
+	maxRawChunkID            int64
+	rawChunksMutex           sync.Mutex
+	activeRawChunksNum       int32
+	releasedRawChunksNum     int64
+	releaseRawChunkInvokeNum int64
+	pool                     NoGCUintptrPool
+	idleRawChunks            idleChunks
+	arenas                   arenas
+	waiters                  chunkWaiters
+	debugger                 *poolDebugger
+
+	// budgetBytesNum is the number of bytes reserved from the MemoryBudget
+	// besides raw chunks, such as the values of a HKVTable
+	budgetBytesNum int64
+	// budgetReleases counts the releaseRawChunkFunc invoked by the MemoryBudget
+	budgetReleases sync.WaitGroup
 }
 
 func (p *RawChunkPool) Init(id int64, rawChunkSize int, rawChunksLimit int32,
 	prepareNewRawChunkFunc RawChunkPoolInvokePrepareNewRawChunk,
-	releaseRawChunkFunc RawChunkPoolInvokeReleaseRawChunk) error {
+	releaseRawChunkFunc RawChunkPoolInvokeReleaseRawChunk,
+	options ...PoolOptions) error {
 	var (
 		err error
 	)
 
+	p.options = getPoolOptions(options)
 	p.ID = id
 	p.rawChunkSize = uintptr(rawChunkSize)
+	if p.options.Debug {
+		p.rawChunkSize = debugChunkSize(p.rawChunkSize)
+	}
 	p.rawChunksLimit = rawChunksLimit
 	if p.rawChunksLimit == -1 {
 		p.perMmapBytesSize = int(1024 * int(p.rawChunkSize))
 	} else {
 		p.perMmapBytesSize = int(math.Ceil(float64(p.rawChunksLimit)/float64(16))) * int(p.rawChunkSize)
 	}
+	if p.options.HugePage != MmapHugePageNone && p.options.Debug == false {
+		p.perMmapBytesSize = (p.perMmapBytesSize + HugePageSize - 1) / HugePageSize * HugePageSize
+	}
 	p.prepareNewRawChunkFunc = prepareNewRawChunkFunc
 	p.releaseRawChunkFunc = releaseRawChunkFunc
 
@@ -55,13 +79,36 @@ func (p *RawChunkPool) Init(id int64, rawChunkSize int, rawChunksLimit int32,
 	}
 
 	p.activeRawChunksNum = 0
-	p.pool.New = p.mallocRawChunk
+
+	if p.options.DisableArenas == false {
+		p.arenas.init(p.rawChunkSize, p.perMmapBytesSize, p.carveRawChunks)
+	}
+
+	if p.options.Debug {
+		p.debugger = newPoolDebugger(p.ID, 0, uintptr(rawChunkSize), p.options)
+	}
+
+	if p.options.FreeChunkIdleDuration > 0 {
+		p.idleRawChunks.start(p.options.FreeChunkIdleDuration, p.AdviseIdleRawChunks)
+	}
+
+	if p.options.MemoryBudget != nil {
+		p.options.MemoryBudget.addPool(p)
+	}
 
 	return nil
 }
 
 func (p *RawChunkPool) growMmapBytesList() error {
-	mmapBytes, err := AllocMmapBytes(int(p.perMmapBytesSize))
+	var (
+		mmapBytes mmapbytes
+		err       error
+	)
+	if p.options.Debug {
+		mmapBytes, err = AllocMmapBytesWithGuardPage(p.perMmapBytesSize)
+	} else {
+		mmapBytes, err = AllocMmapBytesWithHugePage(int(p.perMmapBytesSize), p.options.HugePage)
+	}
 	if err != nil {
 		return err
 	}
@@ -71,73 +118,395 @@ func (p *RawChunkPool) growMmapBytesList() error {
 	return nil
 }
 
-func (p *RawChunkPool) mallocRawChunk() uintptr {
+// carveRawChunks carves at most rawChunksNum raw chunks from the current mmap region,
+// and grows the mmap regions if it is full
+func (p *RawChunkPool) carveRawChunks(rawChunksNum int) (uintptr, uintptr, error) {
 	var (
-		uRawChunk        uintptr
 		currentMmapBytes *mmapbytes
-		end              uintptr
+		start, end       uintptr
 		err              error
 	)
 
-	// step1 grow mem if need
 	currentMmapBytes = p.currentMmapBytes
-	end = atomic.AddUintptr(&currentMmapBytes.addrStart, p.rawChunkSize)
-	if end > currentMmapBytes.addrEnd {
+	start, end = carveMmapBytes(currentMmapBytes, p.rawChunkSize, rawChunksNum)
+	for start == 0 {
 		p.rawChunksMutex.Lock()
+		if currentMmapBytes == p.currentMmapBytes {
+			err = p.growMmapBytesList()
+			if err != nil {
+				p.rawChunksMutex.Unlock()
+				return 0, 0, err
+			}
+		}
 		currentMmapBytes = p.currentMmapBytes
-		end = atomic.AddUintptr(&currentMmapBytes.addrStart, p.rawChunkSize)
-		if end < currentMmapBytes.addrEnd {
-			p.rawChunksMutex.Unlock()
-			goto STEP1_DONE
+		p.rawChunksMutex.Unlock()
+		start, end = carveMmapBytes(currentMmapBytes, p.rawChunkSize, rawChunksNum)
+	}
+
+	return start, end, nil
+}
+
+func (p *RawChunkPool) mallocRawChunk() (uintptr, error) {
+	var (
+		start uintptr
+		err   error
+	)
+
+	// step1 carve mem, from the arena of the current P if enabled
+	if p.arenas.isEnabled() {
+		start, err = p.arenas.alloc()
+	} else {
+		start, _, err = p.carveRawChunks(1)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	p.prepareNewRawChunk(start)
+	return start, nil
+}
+
+func (p *RawChunkPool) prepareNewRawChunk(uRawChunk uintptr) {
+	if p.prepareNewRawChunkFunc != nil {
+		p.prepareNewRawChunkFunc(uRawChunk)
+	}
+}
+
+func (p *RawChunkPool) allocRawChunk() (uintptr, error) {
+	var uRawChunk = p.pool.Get()
+	if uRawChunk == 0 && p.options.FreeChunkIdleDuration > 0 {
+		uRawChunk = p.idleRawChunks.pop()
+		if uRawChunk != 0 && p.prepareNewRawChunkFunc != nil {
+			p.prepareNewRawChunkFunc(uRawChunk)
 		}
+	}
+	if uRawChunk != 0 {
+		if p.debugger != nil {
+			p.debugger.alloc(uRawChunk)
+		}
+		return uRawChunk, nil
+	}
 
-		err = p.growMmapBytesList()
-		if err != nil {
-			p.rawChunksMutex.Unlock()
-			goto STEP1_DONE
+	uRawChunk, err := p.mallocRawChunk()
+	if err == nil && p.debugger != nil {
+		p.debugger.alloc(uRawChunk)
+	}
+	return uRawChunk, err
+}
+
+// isUnlimited is true if raw chunks are alloced without reserving them
+func (p *RawChunkPool) isUnlimited() bool {
+	return p.rawChunksLimit == -1 && p.options.MemoryBudget == nil
+}
+
+func (p *RawChunkPool) reserveRawChunk() bool {
+	for {
+		activeRawChunksNum := atomic.LoadInt32(&p.activeRawChunksNum)
+		if p.rawChunksLimit != -1 && activeRawChunksNum >= p.rawChunksLimit {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&p.activeRawChunksNum, activeRawChunksNum, activeRawChunksNum+1) {
+			break
 		}
+	}
 
-		currentMmapBytes = p.currentMmapBytes
-		end = currentMmapBytes.addrStart + p.rawChunkSize
-		currentMmapBytes.addrStart = end
-		p.rawChunksMutex.Unlock()
+	if p.options.MemoryBudget != nil &&
+		p.options.MemoryBudget.reserve(int64(p.rawChunkSize)) == false {
+		atomic.AddInt32(&p.activeRawChunksNum, -1)
+		return false
 	}
-STEP1_DONE:
 
-	// step2 alloc mem for chunk
-	if err == nil {
-		// get chunk address
-		uRawChunk = (uintptr)(end - p.rawChunkSize)
+	return true
+}
+
+// unreserveRawChunk gives back a raw chunk reserved by reserveRawChunk
+func (p *RawChunkPool) unreserveRawChunk() {
+	atomic.AddInt32(&p.activeRawChunksNum, -1)
+	atomic.AddInt64(&p.releasedRawChunksNum, 1)
+	if p.options.MemoryBudget != nil {
+		p.options.MemoryBudget.release(int64(p.rawChunkSize))
 	}
+	p.waiters.broadcast()
+}
+
+// reserveBudgetBytes takes size bytes alloced besides raw chunks from the
+// MemoryBudget, releasing raw chunks of the pools of the budget while it is
+// exceeded. It returns false if nothing is released.
+func (p *RawChunkPool) reserveBudgetBytes(size int64) bool {
+	var releasedRawChunksNum int64
+	for {
+		releasedRawChunksNum = p.releasedRawChunksNumFunc()
+		if p.options.MemoryBudget.reserve(size) {
+			atomic.AddInt64(&p.budgetBytesNum, size)
+			return true
+		}
+		p.options.MemoryBudget.releaseLargestPool()
+		if p.releasedRawChunksNumFunc() == releasedRawChunksNum {
+			return false
+		}
+	}
+}
+
+// releaseBudgetBytes gives back size bytes taken by reserveBudgetBytes
+func (p *RawChunkPool) releaseBudgetBytes(size int64) {
+	atomic.AddInt64(&p.budgetBytesNum, -size)
+	p.options.MemoryBudget.release(size)
+}
+
+// budgetBytesInUse returns the number of bytes the RawChunkPool takes from its MemoryBudget
+func (p *RawChunkPool) budgetBytesInUse() int64 {
+	return int64(atomic.LoadInt32(&p.activeRawChunksNum))*int64(p.rawChunkSize) +
+		atomic.LoadInt64(&p.budgetBytesNum)
+}
+
+// releasedRawChunksNumFunc counts the raw chunks given back to the RawChunkPool
+// and to the pools of its MemoryBudget, allocators retry to reserve a raw chunk
+// while it grows
+func (p *RawChunkPool) releasedRawChunksNumFunc() int64 {
+	var releasedRawChunksNum = atomic.LoadInt64(&p.releasedRawChunksNum)
+	if p.options.MemoryBudget != nil {
+		releasedRawChunksNum += p.options.MemoryBudget.releasedNum()
+	}
+	return releasedRawChunksNum
+}
+
+// reserveWaiters are the waiters woken up when a raw chunk is released, the
+// ones of the MemoryBudget if any since the other pools of the budget release bytes too
+func (p *RawChunkPool) reserveWaiters() *chunkWaiters {
+	if p.options.MemoryBudget != nil {
+		return &p.options.MemoryBudget.waiters
+	}
+	return &p.waiters
+}
 
+// reserveReleaseFunc returns the func invoked while the limits are reached, or
+// nil if nothing can release raw chunks
+func (p *RawChunkPool) reserveReleaseFunc() RawChunkPoolInvokeReleaseRawChunk {
+	if p.options.MemoryBudget != nil {
+		return p.invokeReleaseRawChunkWithBudget
+	}
+	if p.releaseRawChunkFunc != nil {
+		return p.invokeReleaseRawChunk
+	}
+	return nil
+}
+
+func (p *RawChunkPool) invokeReleaseRawChunk() {
+	atomic.AddInt64(&p.releaseRawChunkInvokeNum, 1)
+	p.releaseRawChunkFunc()
+}
+
+// invokeReleaseRawChunkWithBudget releases a raw chunk of the RawChunkPool if
+// rawChunksLimit is reached, or one of the pool of the MemoryBudget using the most bytes
+func (p *RawChunkPool) invokeReleaseRawChunkWithBudget() {
+	if p.rawChunksLimit != -1 && atomic.LoadInt32(&p.activeRawChunksNum) >= p.rawChunksLimit {
+		if p.releaseRawChunkFunc != nil {
+			p.invokeReleaseRawChunk()
+		}
+		return
+	}
+	p.options.MemoryBudget.releaseLargestPool()
+}
+
+// AllocRawChunkCtx allocs a raw chunk, waiting for a raw chunk to be released while
+// rawChunksLimit is reached and releaseRawChunkFunc does not release any.
+// It returns ErrAllocChunkOurOfLimit if the deadline of ctx is exceeded,
+// ctx.Err() if ctx is canceled, and ErrMmap if the RawChunkPool can not grow.
+func (p *RawChunkPool) AllocRawChunkCtx(ctx context.Context) (uintptr, error) {
+	var (
+		uRawChunk uintptr
+		err       error
+	)
+
+	if p.isUnlimited() {
+		return p.allocUnlimitedRawChunk()
+	}
+
+	err = p.reserveWaiters().reserve(ctx, p.releasedRawChunksNumFunc, p.reserveRawChunk, p.reserveReleaseFunc())
 	if err != nil {
-		panic("malloc chunk error")
+		return 0, err
 	}
 
-	if p.prepareNewRawChunkFunc != nil {
-		p.prepareNewRawChunkFunc(uRawChunk)
+	uRawChunk, err = p.allocRawChunk()
+	if err != nil {
+		p.unreserveRawChunk()
+		return 0, err
+	}
+
+	return uRawChunk, nil
+}
+
+func (p *RawChunkPool) allocUnlimitedRawChunk() (uintptr, error) {
+	uRawChunk, err := p.allocRawChunk()
+	if err != nil {
+		return 0, err
 	}
-	return uintptr(uRawChunk)
+	atomic.AddInt32(&p.activeRawChunksNum, 1)
+	return uRawChunk, nil
 }
 
+// AllocRawChunk is AllocRawChunkCtx without deadline, it panics if the RawChunkPool can not grow
 func (p *RawChunkPool) AllocRawChunk() uintptr {
-	if p.rawChunksLimit == -1 {
-		return p.pool.Get()
+	uRawChunk, err := p.AllocRawChunkCtx(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	return uRawChunk
+}
+
+// TryAllocRawChunk is AllocRawChunk returning an error instead of panicking or
+// waiting forever. It returns ErrMmap if the RawChunkPool can not grow, and
+// ErrAllocChunkOurOfLimit if rawChunksLimit or the MemoryBudget is reached and
+// releaseRawChunkFunc stops releasing raw chunks.
+func (p *RawChunkPool) TryAllocRawChunk() (uintptr, error) {
+	var (
+		uRawChunk            uintptr
+		releaseFunc          = p.reserveReleaseFunc()
+		releasedRawChunksNum int64
+		err                  error
+	)
+
+	if p.isUnlimited() {
+		return p.allocUnlimitedRawChunk()
 	}
 
-	// assert p.rawChunkReleaser != nil
-	if atomic.AddInt32(&p.activeRawChunksNum, 1) < p.rawChunksLimit {
-		return p.pool.Get()
+	for p.reserveRawChunk() == false {
+		if releaseFunc == nil {
+			return 0, ErrAllocChunkOurOfLimit
+		}
+		releasedRawChunksNum = p.releasedRawChunksNumFunc()
+		releaseFunc()
+		// another allocator may take the released raw chunk first, so the
+		// reserve is retried as long as raw chunks are released
+		if p.releasedRawChunksNumFunc() == releasedRawChunksNum {
+			return 0, ErrAllocChunkOurOfLimit
+		}
 	}
 
-	for p.activeRawChunksNum > p.rawChunksLimit {
-		p.releaseRawChunkFunc()
+	uRawChunk, err = p.allocRawChunk()
+	if err != nil {
+		p.unreserveRawChunk()
+		return 0, err
 	}
 
-	return p.pool.Get()
+	return uRawChunk, nil
 }
 
 func (p *RawChunkPool) ReleaseRawChunk(chunk uintptr) {
-	atomic.AddInt32(&p.activeRawChunksNum, -1)
+	if p.debugger != nil {
+		var released bool
+		// the raw chunk is put in quarantine, and another one may leave it
+		chunk, released = p.debugger.release(chunk)
+		if released == false {
+			return
+		}
+	}
 	p.pool.Put(uintptr(chunk))
+	p.unreserveRawChunk()
+}
+
+// Shrink unmaps every mmap region whose rawChunks are all back in the RawChunkPool,
+// and returns the number of bytes released.
+// The region rawChunks are currently carved from is always kept.
+func (p *RawChunkPool) Shrink() (int, error) {
+	var (
+		freeRawChunks      []uintptr
+		freeIdleRawChunks  []uintptr
+		freeArenaRawChunks []uintptr
+		releasedSize       int
+		err                error
+	)
+
+	// arenas lock rawChunksMutex when refilled
+	freeArenaRawChunks = p.arenas.drain()
+
+	p.rawChunksMutex.Lock()
+	freeRawChunks = append(freeRawChunks, freeArenaRawChunks...)
+	freeRawChunks = append(freeRawChunks, p.pool.Drain()...)
+	if p.debugger != nil {
+		freeRawChunks = append(freeRawChunks, p.debugger.flush()...)
+	}
+	freeIdleRawChunks = p.idleRawChunks.takeAll()
+	freeRawChunks = append(freeRawChunks, freeIdleRawChunks...)
+	p.mmapBytesList, freeRawChunks, releasedSize, err = shrinkMmapBytesList(p.mmapBytesList,
+		p.currentMmapBytes, p.rawChunkSize, freeRawChunks)
+	freeRawChunks, freeIdleRawChunks = splitChunks(freeRawChunks, freeIdleRawChunks)
+	// raw chunks of arenas are not prepared yet, so they go back to arenas
+	freeRawChunks, freeArenaRawChunks = splitChunks(freeRawChunks, freeArenaRawChunks)
+	for _, uRawChunk := range freeRawChunks {
+		p.pool.Put(uRawChunk)
+	}
+	p.idleRawChunks.putAll(freeIdleRawChunks)
+	p.arenas.putDrained(freeArenaRawChunks)
+	p.rawChunksMutex.Unlock()
+
+	return releasedSize, err
+}
+
+// Close unmaps every mmap region of the RawChunkPool and unregisters it from
+// its OffheapDriver. RawChunks allocated from the RawChunkPool must not be used after Close.
+func (p *RawChunkPool) Close() error {
+	var err error
+
+	if p.offheapDriver != nil {
+		p.offheapDriver.DeleteRawChunkPool(p.ID)
+		p.offheapDriver = nil
+	}
+	if p.options.MemoryBudget != nil {
+		p.options.MemoryBudget.deletePool(p)
+	}
+
+	p.idleRawChunks.stop()
+	p.arenas.drain()
+
+	p.rawChunksMutex.Lock()
+	p.pool.Reset()
+	for _, mmapBytes := range p.mmapBytesList {
+		if freeErr := FreeMmapBytes(mmapBytes); freeErr != nil && err == nil {
+			err = freeErr
+		}
+	}
+	p.mmapBytesList = nil
+	p.currentMmapBytes = nil
+	if p.options.MemoryBudget != nil {
+		p.options.MemoryBudget.release(p.budgetBytesInUse())
+	}
+	atomic.StoreInt32(&p.activeRawChunksNum, 0)
+	atomic.StoreInt64(&p.budgetBytesNum, 0)
+	p.rawChunksMutex.Unlock()
+
+	return err
+}
+
+// AdviseIdleRawChunks gives back to the kernel the pages of free raw chunks
+// which stayed free since the last AdviseIdleRawChunks, and returns the number
+// of bytes advised. It is invoked every PoolOptions.FreeChunkIdleDuration if set.
+func (p *RawChunkPool) AdviseIdleRawChunks() int {
+	return p.idleRawChunks.advise(p.pool.DrainIdle(), func(uRawChunk uintptr) int {
+		return adviseMmapBytes(uRawChunk, uRawChunk+p.rawChunkSize, syscall.MADV_DONTNEED)
+	})
+}
+
+// Stats returns a snapshot of the statistics of the RawChunkPool
+func (p *RawChunkPool) Stats() PoolStats {
+	var ret = PoolStats{
+		ID:                    p.ID,
+		ChunkSize:             int(p.rawChunkSize),
+		ChunksLimit:           p.rawChunksLimit,
+		ActiveChunksNum:       atomic.LoadInt32(&p.activeRawChunksNum),
+		FreeChunksNum:         p.pool.Len() + p.idleRawChunks.len(),
+		ReleaseChunkInvokeNum: atomic.LoadInt64(&p.releaseRawChunkInvokeNum),
+		WaitersNum:            p.reserveWaiters().len(),
+	}
+	ret.BytesInUse = int64(ret.ActiveChunksNum) * int64(p.rawChunkSize)
+	if p.debugger != nil {
+		ret.ChunkSize = int(p.debugger.dataSize)
+	}
+
+	p.rawChunksMutex.Lock()
+	ret.MmapBytesNum = len(p.mmapBytesList)
+	ret.MmapBytesSize = mmapBytesListSize(p.mmapBytesList)
+	p.rawChunksMutex.Unlock()
+
+	return ret
 }
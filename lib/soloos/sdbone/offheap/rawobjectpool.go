@@ -11,12 +11,13 @@ type RawObjectPool struct {
 
 func (p *RawObjectPool) Init(id int64, structSize int, rawChunksLimit int32,
 	prepareNewRawChunkFunc RawChunkPoolInvokePrepareNewRawChunk,
-	releaseRawChunkFunc RawChunkPoolInvokeReleaseRawChunk) error {
+	releaseRawChunkFunc RawChunkPoolInvokeReleaseRawChunk,
+	options ...PoolOptions) error {
 	var (
 		err error
 	)
 
-	err = p.rawChunkPool.Init(id, structSize, rawChunksLimit, prepareNewRawChunkFunc, releaseRawChunkFunc)
+	err = p.rawChunkPool.Init(id, structSize, rawChunksLimit, prepareNewRawChunkFunc, releaseRawChunkFunc, options...)
 	if err != nil {
 		return err
 	}
@@ -65,3 +66,12 @@ func (p *RawObjectPool) MustGetRawObject(id interface{}) (uintptr, bool) {
 
 	return uRawObject, false
 }
+
+// Close releases every RawObject and unmaps the memory of the RawObjectPool.
+func (p *RawObjectPool) Close() error {
+	p.RawObjects.Range(func(k, v interface{}) bool {
+		p.RawObjects.Delete(k)
+		return true
+	})
+	return p.rawChunkPool.Close()
+}
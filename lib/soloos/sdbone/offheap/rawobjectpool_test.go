@@ -28,7 +28,7 @@ type TPool struct {
 	rawObjectPool RawObjectPool
 }
 
-func (p *TPool) Init(id int32, structSize int, chunksLimit int32) {
+func (p *TPool) Init(id int64, structSize int, chunksLimit int32) {
 	p.rawObjectPool.Init(id, structSize, chunksLimit,
 		p.RawChunkPoolInvokePrepareNewRawChunk,
 		p.RawChunkPoolInvokeReleaseRawChunk)
@@ -120,3 +120,24 @@ func TestRawObjectPool(t *testing.T) {
 		tPool.rawObjectPool.MustGetRawObject(n)
 	}
 }
+
+func benchmarkRawChunkPoolMallocParallel(b *testing.B, options PoolOptions) {
+	var pool RawChunkPool
+	pool.Init(1, 64, -1, nil, nil, options)
+	defer pool.Close()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			pool.mallocRawChunk()
+		}
+	})
+}
+
+func BenchmarkRawChunkPoolMallocParallel(b *testing.B) {
+	benchmarkRawChunkPoolMallocParallel(b, PoolOptions{})
+}
+
+func BenchmarkRawChunkPoolMallocParallelWithoutArenas(b *testing.B) {
+	benchmarkRawChunkPoolMallocParallel(b, PoolOptions{DisableArenas: true})
+}
@@ -0,0 +1,87 @@
+package offheap
+
+// PoolStats is a snapshot of the statistics of a ChunkPool or a RawChunkPool
+type PoolStats struct {
+	ID          int64
+	ChunkSize   int
+	ChunksLimit int32
+
+	ActiveChunksNum int32
+	// BytesInUse is the size of the active chunks
+	BytesInUse int64
+	// FreeChunksNum is the number of chunks waiting in the pool for reuse
+	FreeChunksNum int
+	MmapBytesNum  int
+	MmapBytesSize int
+	// ReleaseChunkInvokeNum is the number of times releaseChunkFunc was
+	// invoked because the chunks limit was reached
+	ReleaseChunkInvokeNum int64
+	// WaitersNum is the number of AllocChunkCtx waiting for a released chunk
+	WaitersNum int32
+}
+
+// OffheapDriverStats is a snapshot of the statistics of every pool
+// registered in an OffheapDriver
+type OffheapDriverStats struct {
+	ChunkPools    []PoolStats
+	RawChunkPools []PoolStats
+
+	ActiveChunksNum       int64
+	BytesInUse            int64
+	FreeChunksNum         int64
+	MmapBytesSize         int64
+	ReleaseChunkInvokeNum int64
+	WaitersNum            int64
+}
+
+func (p *OffheapDriverStats) add(poolStats PoolStats) {
+	p.ActiveChunksNum += int64(poolStats.ActiveChunksNum)
+	p.BytesInUse += poolStats.BytesInUse
+	p.FreeChunksNum += int64(poolStats.FreeChunksNum)
+	p.MmapBytesSize += int64(poolStats.MmapBytesSize)
+	p.ReleaseChunkInvokeNum += poolStats.ReleaseChunkInvokeNum
+	p.WaitersNum += int64(poolStats.WaitersNum)
+}
+
+func mmapBytesListSize(mmapBytesList []*mmapbytes) int {
+	var size int
+	for _, mmapBytes := range mmapBytesList {
+		size += len(mmapBytes.bytes)
+	}
+	return size
+}
+
+func (p *OffheapDriver) Stats() OffheapDriverStats {
+	var (
+		ret           OffheapDriverStats
+		chunkPools    []*ChunkPool
+		rawChunkPools []*RawChunkPool
+	)
+
+	p.poolsRWMutex.RLock()
+	for _, chunkPool := range p.chunkPools {
+		chunkPools = append(chunkPools, chunkPool)
+	}
+	for _, rawChunkPool := range p.rawChunkPools {
+		rawChunkPools = append(rawChunkPools, rawChunkPool)
+	}
+	p.poolsRWMutex.RUnlock()
+
+	// pools are read out of poolsRWMutex, their Stats may lock themselves
+	for _, chunkPool := range chunkPools {
+		poolStats := chunkPool.Stats()
+		ret.ChunkPools = append(ret.ChunkPools, poolStats)
+		ret.add(poolStats)
+	}
+	for _, rawChunkPool := range rawChunkPools {
+		poolStats := rawChunkPool.Stats()
+		ret.RawChunkPools = append(ret.RawChunkPools, poolStats)
+		ret.add(poolStats)
+	}
+
+	return ret
+}
+
+func Stats() OffheapDriverStats {
+	return DefaultOffheapDriver.Stats()
+}